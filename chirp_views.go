@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/views"
+)
+
+const (
+	// Views recorded and not yet stored, past this they are dropped
+	maxPendingViews = 100_000
+	// Stored views are kept while they can still be duplicated
+	viewEventRetention = 2 * views.Window
+
+	defaultStatsHours    = 24
+	maxStatsHours        = 30 * 24
+	defaultAnalyticsDays = 7
+	maxAnalyticsDays     = 90
+	analyticsTopChirps   = 10
+)
+
+type HourlyViews struct {
+	Hour        time.Time `json:"hour"`
+	Impressions int64     `json:"impressions"`
+	Views       int64     `json:"views"`
+}
+
+type ChirpViews struct {
+	ChirpID     uuid.UUID `json:"chirp_id"`
+	Impressions int64     `json:"impressions"`
+	Views       int64     `json:"views"`
+}
+
+// recordViews counts a view of chirps by the viewer of r. Authors viewing
+// their own chirps aren't counted. It only touches memory, the views are
+// stored by flushChirpViews.
+func (cfg *apiConfig) recordViews(r *http.Request, kind views.Kind, chirps ...Chirp) {
+	viewer := viewerFromContext(r.Context())
+
+	chirpIDs := make([]uuid.UUID, 0, len(chirps))
+	for _, chirp := range chirps {
+		if viewer.Valid && viewer.UUID == chirp.UserID {
+			continue
+		}
+		chirpIDs = append(chirpIDs, chirp.ID)
+	}
+
+	if len(chirpIDs) > 0 {
+		cfg.Views.Record(kind, cfg.viewerKey(r), chirpIDs...)
+	}
+}
+
+// viewerKey identifies the viewer of r for deduplication. Anonymous viewers
+// are told apart by their address, which is keyed with the server secret so
+// it can't be recovered from what is stored.
+func (cfg *apiConfig) viewerKey(r *http.Request) string {
+	key := "ip:" + clientIP(r)
+	if viewer := viewerFromContext(r.Context()); viewer.Valid {
+		key = "user:" + viewer.UUID.String()
+	}
+
+	mac := hmac.New(sha256.New, []byte(cfg.SECRET))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// flushChirpViews stores the views recorded since the last run. Views of
+// chirps that are gone are skipped, views already stored by this or another
+// instance are ignored. The views that couldn't be stored are put back in
+// the recorder for the next run.
+func (cfg *apiConfig) flushChirpViews(ctx context.Context) error {
+	recorded, dropped := cfg.Views.Drain()
+	if dropped > 0 {
+		log.Printf("Dropped %d chirp views, the recorder was full", dropped)
+	}
+
+	byWindow := map[time.Time][]views.View{}
+	for _, view := range recorded {
+		byWindow[view.Window] = append(byWindow[view.Window], view)
+	}
+
+	var errs []error
+	for window, batch := range byWindow {
+		params := database.RecordChirpViewsParams{WindowStart: window}
+		for _, view := range batch {
+			params.ChirpIds = append(params.ChirpIds, view.ChirpID)
+			params.Kinds = append(params.Kinds, string(view.Kind))
+			params.Viewers = append(params.Viewers, view.Viewer)
+		}
+
+		if _, err := cfg.DB.RecordChirpViews(ctx, params); err != nil {
+			cfg.Views.Restore(batch)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// rollupChirpViews adds the stored views to the hourly rollups, then deletes
+// the ones that can't be duplicated anymore.
+func (cfg *apiConfig) rollupChirpViews(ctx context.Context) error {
+	if _, err := cfg.DB.RollupChirpViews(ctx); err != nil {
+		return err
+	}
+
+	_, err := cfg.DB.PruneChirpViewEvents(ctx, time.Now().UTC().Add(-viewEventRetention))
+	return err
+}
+
+// handlerChirpStats returns the views of one of the caller's chirps, in
+// total and per hour for the last hours (24 by default). Views show up
+// after they are rolled up, within a couple of minutes.
+func (cfg *apiConfig) handlerChirpStats(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ChirpViews
+		Hourly []HourlyViews `json:"hourly"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	hours, err := parsePeriod(r, "hours", defaultStatsHours, maxStatsHours)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	if caller.UserID != chirp.UserID {
+		respondWithError(w, http.StatusForbidden, "Forbidden: only the author can see the stats of a chirp", nil)
+		return
+	}
+
+	totals, err := cfg.DB.GetChirpViewTotals(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	hourly, err := cfg.DB.GetChirpHourlyViews(r.Context(), database.GetChirpHourlyViewsParams{
+		ChirpID: chirpID,
+		Since:   periodStart(time.Duration(hours) * time.Hour),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	resp := response{
+		ChirpViews: ChirpViews{
+			ChirpID:     chirpID,
+			Impressions: totals.Impressions,
+			Views:       totals.Views,
+		},
+		Hourly: make([]HourlyViews, 0, len(hourly)),
+	}
+	for _, row := range hourly {
+		resp.Hourly = append(resp.Hourly, HourlyViews{
+			Hour:        row.Hour,
+			Impressions: row.Impressions,
+			Views:       row.Views,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAuthorAnalytics returns the views of all of the caller's chirps
+// for the last days (7 by default): the totals, per hour and the most
+// viewed chirps.
+func (cfg *apiConfig) handlerAuthorAnalytics(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Since       time.Time     `json:"since"`
+		Impressions int64         `json:"impressions"`
+		Views       int64         `json:"views"`
+		Hourly      []HourlyViews `json:"hourly"`
+		TopChirps   []ChirpViews  `json:"top_chirps"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	days, err := parsePeriod(r, "days", defaultAnalyticsDays, maxAnalyticsDays)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	since := periodStart(time.Duration(days) * 24 * time.Hour)
+
+	hourly, err := cfg.DB.GetAuthorHourlyViews(r.Context(), database.GetAuthorHourlyViewsParams{
+		UserID: caller.UserID,
+		Since:  since,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	top, err := cfg.DB.GetAuthorTopChirps(r.Context(), database.GetAuthorTopChirpsParams{
+		UserID:     caller.UserID,
+		Since:      since,
+		LimitCount: analyticsTopChirps,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	resp := response{
+		Since:     since,
+		Hourly:    make([]HourlyViews, 0, len(hourly)),
+		TopChirps: make([]ChirpViews, 0, len(top)),
+	}
+	for _, row := range hourly {
+		resp.Impressions += row.Impressions
+		resp.Views += row.Views
+		resp.Hourly = append(resp.Hourly, HourlyViews{
+			Hour:        row.Hour,
+			Impressions: row.Impressions,
+			Views:       row.Views,
+		})
+	}
+	for _, row := range top {
+		resp.TopChirps = append(resp.TopChirps, ChirpViews{
+			ChirpID:     row.ChirpID,
+			Impressions: row.Impressions,
+			Views:       row.Views,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// parsePeriod reads the length of a stats period from the query parameter
+// name.
+func parsePeriod(r *http.Request, name string, defaultValue, maxValue int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 1 || value > maxValue {
+		return 0, errors.New(name + " must be between 1 and " + strconv.Itoa(maxValue))
+	}
+
+	return value, nil
+}
+
+// periodStart returns the start of the hour the period of length d starts
+// in, rollups are hourly.
+func periodStart(d time.Duration) time.Time {
+	return time.Now().UTC().Add(-d).Truncate(views.Window)
+}
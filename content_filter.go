@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/contentfilter"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+// Notified by the filter_rules trigger whenever the rules change
+const filterRulesChannel = "filter_rules_changed"
+
+// reloadContentFilter loads the filter rules from the database into the
+// pipeline. Chirps being checked while it runs use the previous rules.
+func (cfg *apiConfig) reloadContentFilter(ctx context.Context) error {
+	stored, err := cfg.DB.ListFilterRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	rules := make([]contentfilter.Rule, 0, len(stored))
+	for _, rule := range stored {
+		rules = append(rules, contentfilter.Rule{
+			ID:     rule.ID,
+			Term:   rule.Term,
+			Action: contentfilter.Action(rule.Action),
+		})
+	}
+
+	cfg.ContentFilter.Load(rules)
+	return nil
+}
+
+// filterContentWarning runs a chirp's content warning through the filter
+// like its body, the warning returned has the masked words replaced.
+func (cfg *apiConfig) filterContentWarning(warning sql.NullString) (sql.NullString, contentfilter.Result) {
+	if !warning.Valid {
+		return warning, contentfilter.Result{}
+	}
+
+	result := cfg.ContentFilter.Apply(warning.String)
+	return sql.NullString{String: result.Text, Valid: true}, result
+}
+
+// flagChirp records the flag matches found in body for review.
+func flagChirp(ctx context.Context, q *database.Queries, chirpID uuid.UUID, body string, result contentfilter.Result) error {
+	for _, match := range result.Flagged() {
+		err := q.CreateChirpFlag(ctx, database.CreateChirpFlagParams{
+			ChirpID: chirpID,
+			RuleID:  uuid.NullUUID{UUID: match.Rule.ID, Valid: true},
+			Term:    body[match.Start:match.End],
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
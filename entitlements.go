@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/billing"
+)
+
+type entitlementsContextKey struct{}
+
+func entitlementsFromContext(ctx context.Context) billing.Entitlements {
+	entitlements, ok := ctx.Value(entitlementsContextKey{}).(billing.Entitlements)
+	if !ok {
+		return billing.EntitlementsFor(false)
+	}
+
+	return entitlements
+}
+
+func (cfg *apiConfig) entitlementsFor(ctx context.Context, userID uuid.UUID) (billing.Entitlements, error) {
+	isChirpyRed, err := cfg.DB.IsChirpyRed(ctx, userID)
+	if err != nil {
+		return billing.Entitlements{}, err
+	}
+
+	return billing.EntitlementsFor(isChirpyRed), nil
+}
+
+// middlewareEntitlements loads the caller's entitlements and applies their
+// rate limit. It must run behind middlewareAuth, handlers read the
+// entitlements with entitlementsFromContext.
+func (cfg *apiConfig) middlewareEntitlements(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromContext(r.Context())
+
+		entitlements, err := cfg.entitlementsFor(r.Context(), caller.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+			return
+		}
+
+		if ok, wait := cfg.RateLimiter.Allow(caller.UserID.String(), entitlements.RequestsPerMinute); !ok {
+			respondWithRetryAfter(w, wait, "Too many requests, try again later")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), entitlementsContextKey{}, entitlements)))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/outbox"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
+)
+
+// Domain events, their payload is the API representation of the resource
+// or, for the follow, mention, reply and like events, one of the event types
+// below. The
+// events webhooks can subscribe to are declared in the webhooks package.
+const (
+	eventChirpUpdated        = "chirp.updated"
+	eventNotificationCreated = "notification.created"
+	eventFollowRequested     = "follow.requested"
+	eventChirpReplied        = "chirp.replied"
+	eventChirpLiked          = "chirp.liked"
+)
+
+// FollowEvent is the payload of webhooks.EventUserFollowed, published when
+// a follow starts, and of eventFollowRequested, published when a private
+// account is asked to approve one. UserID is the followed user.
+type FollowEvent struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowerID uuid.UUID `json:"follower_id"`
+}
+
+// UserMentionedEvent is the payload of webhooks.EventUserMentioned,
+// published for every user mentioned in a chirp.
+type UserMentionedEvent struct {
+	UserID uuid.UUID `json:"user_id"`
+	Chirp  Chirp     `json:"chirp"`
+}
+
+// ChirpRepliedEvent is the payload of eventChirpReplied, published when a
+// chirp answers another. UserID is the author of the chirp answered.
+type ChirpRepliedEvent struct {
+	UserID uuid.UUID `json:"user_id"`
+	Chirp  Chirp     `json:"chirp"`
+}
+
+// ChirpLikedEvent is the payload of eventChirpLiked. UserID is the author of
+// the chirp liked.
+type ChirpLikedEvent struct {
+	UserID  uuid.UUID `json:"user_id"`
+	ChirpID uuid.UUID `json:"chirp_id"`
+	LikerID uuid.UUID `json:"liker_id"`
+}
+
+const (
+	outboxBatchSize = 50
+	outboxLease     = time.Minute
+	// Published events are kept for a while to help debugging
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// registerEventSubscribers wires the in-process subscribers of the outbox.
+func (cfg *apiConfig) registerEventSubscribers() {
+	for _, eventType := range webhooks.Events {
+		cfg.Events.Subscribe(eventType, "webhooks", cfg.forwardToWebhooks)
+	}
+	cfg.Events.Subscribe(webhooks.EventUserFollowed, "notifications", cfg.notifyFollow)
+	cfg.Events.Subscribe(eventFollowRequested, "notifications", cfg.notifyFollow)
+	cfg.Events.Subscribe(webhooks.EventUserMentioned, "notifications", cfg.notifyMention)
+	cfg.Events.Subscribe(eventChirpReplied, "notifications", cfg.notifyReply)
+	cfg.Events.Subscribe(eventChirpLiked, "notifications", cfg.notifyLike)
+	cfg.Events.Subscribe(webhooks.EventChirpCreated, "link-previews", cfg.queueLinkPreviews)
+	cfg.Events.Subscribe(eventChirpUpdated, "link-previews", cfg.queueLinkPreviews)
+}
+
+// publishEvent writes an event to the outbox. q must be bound to the
+// transaction making the change the event describes, so that the event is
+// only published if the change is committed.
+func publishEvent(ctx context.Context, q *database.Queries, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return q.CreateOutboxEvent(ctx, database.CreateOutboxEventParams{
+		EventType: eventType,
+		Payload:   data,
+	})
+}
+
+// dispatchOutbox hands pending events to their subscribers.
+func (cfg *apiConfig) dispatchOutbox(ctx context.Context) error {
+	events, err := cfg.DB.ClaimOutboxEvents(ctx, database.ClaimOutboxEventsParams{
+		LeaseUntil: time.Now().UTC().Add(outboxLease),
+		BatchSize:  outboxBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(events, func(a, b database.Outbox) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	for _, event := range events {
+		dispatchErr := cfg.Events.Dispatch(ctx, outbox.Event{
+			ID:        event.ID,
+			Type:      event.EventType,
+			CreatedAt: event.CreatedAt,
+			Payload:   event.Payload,
+		})
+		if dispatchErr == nil {
+			err = cfg.DB.MarkOutboxEventPublished(ctx, event.ID)
+		} else {
+			log.Printf("Couldn't dispatch event %s (%s): %v", event.ID, event.EventType, dispatchErr)
+			err = cfg.DB.FailOutboxEvent(ctx, database.FailOutboxEventParams{
+				ID:            event.ID,
+				NextAttemptAt: time.Now().UTC().Add(outbox.Backoff(int(event.Attempts) + 1)),
+				LastError:     sql.NullString{String: dispatchErr.Error(), Valid: true},
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) pruneOutbox(ctx context.Context) error {
+	_, err := cfg.DB.DeletePublishedOutboxEvents(ctx, sql.NullTime{
+		Time:  time.Now().UTC().Add(-outboxRetention),
+		Valid: true,
+	})
+	return err
+}
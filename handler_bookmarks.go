@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+// Users can pin a few of their chirps to the top of their profile
+const maxPinnedChirps = 3
+
+func (cfg *apiConfig) handlerAddBookmark(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChirpID uuid.UUID `json:"chirp_id"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	params := parameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		return
+	}
+
+	// Only chirps the caller can read can be bookmarked
+	if _, err := cfg.DB.GetVisibleChirp(r.Context(), database.GetVisibleChirpParams{
+		ID:       params.ChirpID,
+		ViewerID: uuid.NullUUID{UUID: caller.UserID, Valid: true},
+	}); err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	if err := cfg.DB.AddBookmark(r.Context(), database.AddBookmarkParams{
+		UserID:  caller.UserID,
+		ChirpID: params.ChirpID,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't bookmark chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerDeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteBookmark(r.Context(), database.DeleteBookmarkParams{
+		UserID:  caller.UserID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't remove bookmark", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Bookmark not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerListBookmarks returns the caller's bookmarked chirps, the most
+// recently bookmarked first. Chirps the caller can no longer read are left
+// out.
+func (cfg *apiConfig) handlerListBookmarks(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	chirps, err := cfg.DB.ListBookmarkedChirps(r.Context(), database.ListBookmarkedChirpsParams{
+		UserID:      caller.UserID,
+		LimitCount:  limit,
+		OffsetCount: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := mapChirps(chirps)
+	cfg.attachLinkPreviews(r.Context(), response)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerPinChirp(w http.ResponseWriter, r *http.Request) {
+	chirp, ok := cfg.chirpToPinFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if chirp.PinnedAt.Valid {
+		respondWithJSON(w, http.StatusNoContent, nil)
+		return
+	}
+
+	// The author's row is locked so concurrent pins can't both see room
+	// for one more
+	var pinned int64
+	err := cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		if err := q.LockUserPins(r.Context(), chirp.UserID); err != nil {
+			return err
+		}
+
+		var err error
+		pinned, err = q.PinChirp(r.Context(), database.PinChirpParams{
+			ID:        chirp.ID,
+			MaxPinned: maxPinnedChirps,
+		})
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't pin chirp", err)
+		return
+	}
+	if pinned == 0 {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Can't pin more than %d chirps, unpin one first", maxPinnedChirps), nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerUnpinChirp(w http.ResponseWriter, r *http.Request) {
+	chirp, ok := cfg.chirpToPinFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.UnpinChirp(r.Context(), chirp.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't unpin chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// chirpToPinFromPath loads the chirp in the path and checks the caller wrote
+// it, responding with the error otherwise.
+func (cfg *apiConfig) chirpToPinFromPath(w http.ResponseWriter, r *http.Request) (database.Chirp, bool) {
+	caller, _ := principalFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return database.Chirp{}, false
+	}
+
+	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", nil)
+		return database.Chirp{}, false
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return database.Chirp{}, false
+	}
+
+	if caller.UserID != chirp.UserID {
+		respondWithError(w, http.StatusForbidden, "Forbidden: can't pin chirps from other users!", nil)
+		return database.Chirp{}, false
+	}
+
+	return chirp, true
+}
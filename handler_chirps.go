@@ -1,64 +1,145 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
+	"slices"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/miguelsoffarelli/chirpy/internal/auth"
 	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/views"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
 )
 
 type chirpParameters struct {
-	Body string `json:"body"`
+	Body           string      `json:"body"`
+	Media          []string    `json:"media"`
+	ContentWarning *string     `json:"content_warning"`
+	Sensitive      *bool       `json:"sensitive"`
+	Visibility     string      `json:"visibility"`
+	Mentions       []uuid.UUID `json:"mentions"`
+	// Only used when creating a chirp, replies can't be moved
+	ReplyToID *uuid.UUID `json:"reply_to_id"`
 }
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	Body           string     `json:"body"`
+	UserID         uuid.UUID  `json:"user_id"`
+	Media          []string   `json:"media"`
+	URLs           []ChirpURL `json:"urls"`
+	ContentWarning *string    `json:"content_warning"`
+	Sensitive      bool       `json:"sensitive"`
+	Visibility     string     `json:"visibility"`
+	ReplyToID      *uuid.UUID `json:"reply_to_id"`
+	// Only set in the listing of an author's chirps
+	Pinned *bool `json:"pinned,omitempty"`
+	// Only set for chirps in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authentication error: couldn't get access token", err)
+	caller, _ := principalFromContext(r.Context())
+
+	params := chirpParameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "The request body isn't valid JSON", err)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.SECRET)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authentication error: invalid or expired token", err)
+	v := &validator{}
+	validateChirp(v, &params, entitlementsFromContext(r.Context()))
+	validateChirpAudience(v, &params)
+	contentWarning := validateContentWarning(v, params.ContentWarning)
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
 		return
 	}
 
-	params := chirpParameters{}
-	if err = decodeJSON(r, &params); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
-		return
+	var parent database.Chirp
+	if params.ReplyToID != nil {
+		var ok bool
+		var err error
+		parent, ok, err = cfg.replyParent(r.Context(), caller.UserID, *params.ReplyToID)
+		if err != nil {
+			respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't create chirp", err)
+			return
+		}
+		if !ok {
+			v.add("reply_to_id", fieldInvalid, "Can only reply to chirps you can read")
+			respondWithValidationProblem(w, v)
+			return
+		}
 	}
 
-	if !validateChirp(&params) {
-		respondWithError(w, http.StatusBadRequest, "Invalid chirp", nil)
+	filtered := cfg.ContentFilter.Apply(params.Body)
+	warning, filteredWarning := cfg.filterContentWarning(contentWarning)
+	if filtered.Rejected() || filteredWarning.Rejected() {
+		respondWithProblem(w, http.StatusBadRequest, problemContentRejected, "Chirp contains words that aren't allowed", nil)
 		return
 	}
 
 	createChirpParams := database.CreateChirpParams{
-		Body:   params.Body,
-		UserID: userID,
+		Body:           filtered.Text,
+		UserID:         caller.UserID,
+		MediaUrls:      params.Media,
+		ContentWarning: warning,
+		Sensitive:      params.Sensitive != nil && *params.Sensitive,
+		Visibility:     params.Visibility,
+	}
+	if params.ReplyToID != nil {
+		createChirpParams.ReplyToID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+	if createChirpParams.MediaUrls == nil {
+		createChirpParams.MediaUrls = []string{}
 	}
 
-	chirp, err := cfg.DB.CreateChirp(r.Context(), createChirpParams)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+	var chirp database.Chirp
+	err := cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		chirp, err = q.CreateChirp(r.Context(), createChirpParams)
+		if err != nil {
+			return err
+		}
+
+		if err := flagChirp(r.Context(), q, chirp.ID, params.Body, filtered); err != nil {
+			return err
+		}
+		if err := flagChirp(r.Context(), q, chirp.ID, contentWarning.String, filteredWarning); err != nil {
+			return err
+		}
+
+		if err := mentionUsers(r.Context(), q, chirp, params.Mentions); err != nil {
+			return err
+		}
+
+		if chirp.ReplyToID.Valid {
+			if err := publishEvent(r.Context(), q, eventChirpReplied, ChirpRepliedEvent{
+				UserID: parent.UserID,
+				Chirp:  mapChirp(chirp),
+			}); err != nil {
+				return err
+			}
+		}
+
+		return publishEvent(r.Context(), q, webhooks.EventChirpCreated, mapChirp(chirp))
+	})
+	if isForeignKeyError(err) {
+		v.add("mentions", fieldInvalid, "Mentioned users must exist")
+		respondWithValidationProblem(w, v)
+		return
+	} else if err != nil {
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't create chirp", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, mapChirp(chirp))
+	respondWithJSON(w, http.StatusCreated, cfg.mapChirpWithPreviews(r.Context(), chirp))
 }
 
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
@@ -67,6 +148,18 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 	author := r.URL.Query().Get("author_id")
 	sortBy := r.URL.Query().Get("sort")
 	var authorID uuid.UUID
+	viewer := viewerFromContext(r.Context())
+
+	sensitive, err := parseBoolFilter(r, "sensitive")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid sensitive filter", err)
+		return
+	}
+	hasWarning, err := parseBoolFilter(r, "has_warning")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid has_warning filter", err)
+		return
+	}
 
 	if author != "" {
 		authorID, err = uuid.Parse(author)
@@ -75,7 +168,12 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		chirps, err = cfg.DB.GetChirpsByAuthor(r.Context(), authorID)
+		chirps, err = cfg.DB.GetChirpsByAuthor(r.Context(), database.GetChirpsByAuthorParams{
+			UserID:     authorID,
+			ViewerID:   viewer,
+			Sensitive:  sensitive,
+			HasWarning: hasWarning,
+		})
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
 			return
@@ -86,7 +184,11 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		chirps, err = cfg.DB.GetChirps(r.Context())
+		chirps, err = cfg.DB.GetChirps(r.Context(), database.GetChirpsParams{
+			ViewerID:   viewer,
+			Sensitive:  sensitive,
+			HasWarning: hasWarning,
+		})
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
 			return
@@ -101,13 +203,28 @@ func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
 	chirpsSlice := make([]Chirp, 0)
 
 	for _, chirp := range chirps {
-		chirpsSlice = append(chirpsSlice, mapChirp(chirp))
+		response := mapChirp(chirp)
+		if author != "" {
+			pinned := chirp.PinnedAt.Valid
+			response.Pinned = &pinned
+		}
+		chirpsSlice = append(chirpsSlice, response)
 	}
 
 	if sortBy == "desc" {
-		sort.Slice(chirpsSlice, func(i, j int) bool { return chirpsSlice[i].CreatedAt.After(chirpsSlice[j].CreatedAt) })
+		// Pinned chirps stay on top, in the order they come in
+		sort.SliceStable(chirpsSlice, func(i, j int) bool {
+			if pi, pj := isPinned(chirpsSlice[i]), isPinned(chirpsSlice[j]); pi || pj {
+				return pi && !pj
+			}
+			return chirpsSlice[i].CreatedAt.After(chirpsSlice[j].CreatedAt)
+		})
 	} // no need to sort in case of "asc" or default (no sort query) because
-	// the results already come sorted in ASC order from the database
+	// the results already come sorted in ASC order from the database, after
+	// the pinned ones
+
+	cfg.attachLinkPreviews(r.Context(), chirpsSlice)
+	cfg.recordViews(r, views.Impression, chirpsSlice...)
 
 	respondWithJSON(w, http.StatusOK, chirpsSlice)
 }
@@ -119,7 +236,11 @@ func (cfg *apiConfig) handleGetChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	// Chirps the viewer can't see don't exist for them
+	chirp, err := cfg.DB.GetVisibleChirp(r.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: viewerFromContext(r.Context()),
+	})
 	if err == sql.ErrNoRows {
 		respondWithError(w, http.StatusNotFound, "Chirp not found", nil)
 		return
@@ -128,21 +249,110 @@ func (cfg *apiConfig) handleGetChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, mapChirp(chirp))
+	response := cfg.mapChirpWithPreviews(r.Context(), chirp)
+	cfg.recordViews(r, views.Detail, response)
+
+	respondWithJSON(w, http.StatusOK, response)
 }
 
-func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	entitlements := entitlementsFromContext(r.Context())
+	if !entitlements.CanEditChirps {
+		respondWithProblem(w, http.StatusForbidden, problemRequiresRed, "Editing chirps requires Chirpy Red", nil)
+		return
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authentication error: couldn't get access token", err)
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidChirpID, "Invalid chirp ID", err)
+		return
+	}
+
+	params := chirpParameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "The request body isn't valid JSON", err)
+		return
+	}
+
+	// Media can't be edited
+	v := &validator{}
+	params.Media = nil
+	validateChirp(v, &params, entitlements)
+	contentWarning := validateContentWarning(v, params.ContentWarning)
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
+		return
+	}
+
+	filtered := cfg.ContentFilter.Apply(params.Body)
+	warning, filteredWarning := cfg.filterContentWarning(contentWarning)
+	if filtered.Rejected() || filteredWarning.Rejected() {
+		respondWithProblem(w, http.StatusBadRequest, problemContentRejected, "Chirp contains words that aren't allowed", nil)
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(r.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		respondWithProblem(w, http.StatusNotFound, problemChirpNotFound, "Chirp not found", nil)
+		return
+	} else if err != nil {
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't update chirp", err)
+		return
+	}
+
+	if caller.UserID != chirp.UserID {
+		respondWithProblem(w, http.StatusForbidden, problemNotChirpAuthor, "Can't edit chirps from other users", nil)
+		return
+	}
+
+	// The warning and sensitive flag are only changed when sent, and a
+	// warning applied by a moderator stays whatever the author sends
+	update := database.UpdateChirpParams{
+		ID:             chirpID,
+		Body:           filtered.Text,
+		ContentWarning: chirp.ContentWarning,
+		Sensitive:      chirp.Sensitive,
+	}
+	authorWarning := !chirp.WarningAppliedBy.Valid && params.ContentWarning != nil
+	if authorWarning {
+		update.ContentWarning = warning
 	}
+	if !chirp.WarningAppliedBy.Valid && params.Sensitive != nil {
+		update.Sensitive = *params.Sensitive
+	}
+
+	var updated database.Chirp
+	err = cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		updated, err = q.UpdateChirp(r.Context(), update)
+		if err != nil {
+			return err
+		}
+
+		if err := flagChirp(r.Context(), q, chirpID, params.Body, filtered); err != nil {
+			return err
+		}
+		if authorWarning {
+			if err := flagChirp(r.Context(), q, chirpID, contentWarning.String, filteredWarning); err != nil {
+				return err
+			}
+		}
 
-	userID, err := auth.ValidateJWT(token, cfg.SECRET)
+		return publishEvent(r.Context(), q, eventChirpUpdated, mapChirp(updated))
+	})
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authentication error: invalid or expired token", err)
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't update chirp", err)
 		return
 	}
 
+	respondWithJSON(w, http.StatusOK, cfg.mapChirpWithPreviews(r.Context(), updated))
+}
+
+func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
 	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
@@ -158,12 +368,21 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if userID != chirp.UserID {
+	if caller.UserID != chirp.UserID {
 		respondWithError(w, http.StatusForbidden, "Forbidden: can't delete chirps from other users!", err)
 		return
 	}
 
-	if err := cfg.DB.DeleteChirp(r.Context(), chirpID); err != nil {
+	// The chirp goes to the trash, see handlerRestoreChirp
+	err = cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		deleted, err := q.SoftDeleteChirp(r.Context(), chirpID)
+		if err != nil {
+			return err
+		}
+
+		return publishEvent(r.Context(), q, webhooks.EventChirpDeleted, mapChirp(deleted))
+	})
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't delete chirp", err)
 		return
 	}
@@ -172,11 +391,112 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 }
 
 func mapChirp(chirp database.Chirp) Chirp {
-	return Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
+	response := Chirp{
+		ID:         chirp.ID,
+		CreatedAt:  chirp.CreatedAt,
+		UpdatedAt:  chirp.UpdatedAt,
+		Body:       chirp.Body,
+		UserID:     chirp.UserID,
+		Media:      chirp.MediaUrls,
+		URLs:       chirpURLs(chirp.Body),
+		Sensitive:  chirp.Sensitive,
+		Visibility: chirp.Visibility,
+		DeletedAt:  nullTimePtr(chirp.DeletedAt),
+	}
+	if chirp.ContentWarning.Valid {
+		response.ContentWarning = &chirp.ContentWarning.String
+	}
+	if chirp.ReplyToID.Valid {
+		response.ReplyToID = &chirp.ReplyToID.UUID
+	}
+
+	return response
+}
+
+// replyParent loads the chirp a new chirp of the caller answers. ok is false
+// if the caller can't read it or either of them blocks the other.
+func (cfg *apiConfig) replyParent(ctx context.Context, callerID, chirpID uuid.UUID) (chirp database.Chirp, ok bool, err error) {
+	chirp, err = cfg.DB.GetVisibleChirp(ctx, database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: uuid.NullUUID{UUID: callerID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		return database.Chirp{}, false, nil
+	} else if err != nil {
+		return database.Chirp{}, false, err
+	}
+
+	blocked, err := cfg.DB.IsBlockedEitherWay(ctx, database.IsBlockedEitherWayParams{
+		BlockerID: chirp.UserID,
+		BlockedID: callerID,
+	})
+	if err != nil {
+		return database.Chirp{}, false, err
+	}
+
+	return chirp, !blocked, nil
+}
+
+func isPinned(chirp Chirp) bool {
+	return chirp.Pinned != nil && *chirp.Pinned
+}
+
+// mentionUsers records the users mentioned in a new chirp, who can always
+// read it, and publishes a mention event for each, which notifies them.
+// Users blocking the author or blocked by them aren't mentioned.
+func mentionUsers(ctx context.Context, q *database.Queries, chirp database.Chirp, mentions []uuid.UUID) error {
+	recipients := make([]uuid.UUID, 0, len(mentions))
+	for _, userID := range mentions {
+		if userID == chirp.UserID || slices.Contains(recipients, userID) {
+			continue
+		}
+
+		blocked, err := q.IsBlockedEitherWay(ctx, database.IsBlockedEitherWayParams{
+			BlockerID: chirp.UserID,
+			BlockedID: userID,
+		})
+		if err != nil {
+			return err
+		}
+		if !blocked {
+			recipients = append(recipients, userID)
+		}
 	}
+
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	if err := q.AddChirpMentions(ctx, database.AddChirpMentionsParams{
+		ChirpID: chirp.ID,
+		UserIds: recipients,
+	}); err != nil {
+		return err
+	}
+
+	for _, userID := range recipients {
+		if err := publishEvent(ctx, q, webhooks.EventUserMentioned, UserMentionedEvent{
+			UserID: userID,
+			Chirp:  mapChirp(chirp),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseBoolFilter reads an optional boolean query parameter.
+func parseBoolFilter(r *http.Request, name string) (sql.NullBool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return sql.NullBool{}, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return sql.NullBool{}, err
+	}
+
+	return sql.NullBool{Bool: b, Valid: true}, nil
 }
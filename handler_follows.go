@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
+)
+
+const (
+	dmPolicyEveryone  = "everyone"
+	dmPolicyFollowers = "followers"
+)
+
+const (
+	followPending  = "pending"
+	followAccepted = "accepted"
+)
+
+type FollowRequest struct {
+	FollowerID uuid.UUID `json:"follower_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) handlerFollowUser(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	target, ok := cfg.otherUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	blocked, err := cfg.DB.IsBlockedEitherWay(r.Context(), database.IsBlockedEitherWayParams{
+		BlockerID: caller.UserID,
+		BlockedID: target.ID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	} else if blocked {
+		respondWithError(w, http.StatusForbidden, "Forbidden: can't follow this user", nil)
+		return
+	}
+
+	// Private accounts approve their followers
+	status, eventType := followAccepted, webhooks.EventUserFollowed
+	if target.IsPrivate {
+		status, eventType = followPending, eventFollowRequested
+	}
+
+	err = cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		followed, err := q.FollowUser(r.Context(), database.FollowUserParams{
+			FollowerID: caller.UserID,
+			FolloweeID: target.ID,
+			Status:     status,
+		})
+		if err != nil {
+			return err
+		}
+		if followed == 0 {
+			// Already following or requested
+			status, err = q.GetFollowStatus(r.Context(), database.GetFollowStatusParams{
+				FollowerID: caller.UserID,
+				FolloweeID: target.ID,
+			})
+			return err
+		}
+
+		return publishEvent(r.Context(), q, eventType, FollowEvent{
+			UserID:     target.ID,
+			FollowerID: caller.UserID,
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't follow user", err)
+		return
+	}
+
+	if status == followPending {
+		respondWithJSON(w, http.StatusAccepted, struct {
+			Status string `json:"status"`
+		}{Status: status})
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	target, ok := cfg.otherUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.DB.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: caller.UserID,
+		FolloweeID: target.ID,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't unfollow user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerBlockUser also removes follows in both directions.
+func (cfg *apiConfig) handlerBlockUser(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	target, ok := cfg.otherUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		if err := q.BlockUser(r.Context(), database.BlockUserParams{
+			BlockerID: caller.UserID,
+			BlockedID: target.ID,
+		}); err != nil {
+			return err
+		}
+
+		return q.RemoveFollowsBetween(r.Context(), database.RemoveFollowsBetweenParams{
+			FollowerID: caller.UserID,
+			FolloweeID: target.ID,
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't block user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerUnblockUser(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	target, ok := cfg.otherUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.DB.UnblockUser(r.Context(), database.UnblockUserParams{
+		BlockerID: caller.UserID,
+		BlockedID: target.ID,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't unblock user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerUpdatePrivacy changes the settings that are sent, the others are
+// kept. Making a private account public accepts its pending follow requests.
+func (cfg *apiConfig) handlerUpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		DMPolicy string `json:"dm_policy"`
+		Private  *bool  `json:"private"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	params := parameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		return
+	}
+
+	if params.DMPolicy != "" && params.DMPolicy != dmPolicyEveryone && params.DMPolicy != dmPolicyFollowers {
+		respondWithError(w, http.StatusBadRequest, "dm_policy must be everyone or followers", nil)
+		return
+	}
+
+	var user database.User
+	err := cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		current, err := q.GetUserByID(r.Context(), caller.UserID)
+		if err != nil {
+			return err
+		}
+
+		update := database.UpdatePrivacyParams{
+			ID:        caller.UserID,
+			DmPolicy:  current.DmPolicy,
+			IsPrivate: current.IsPrivate,
+		}
+		if params.DMPolicy != "" {
+			update.DmPolicy = params.DMPolicy
+		}
+		if params.Private != nil {
+			update.IsPrivate = *params.Private
+		}
+
+		user, err = q.UpdatePrivacy(r.Context(), update)
+		if err != nil {
+			return err
+		}
+
+		if current.IsPrivate && !user.IsPrivate {
+			return q.AcceptAllFollowRequests(r.Context(), user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update privacy settings", err)
+		return
+	}
+
+	private := user.IsPrivate
+	respondWithJSON(w, http.StatusOK, parameters{DMPolicy: user.DmPolicy, Private: &private})
+}
+
+func (cfg *apiConfig) handlerListFollowRequests(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	requests, err := cfg.DB.ListFollowRequests(r.Context(), database.ListFollowRequestsParams{
+		FolloweeID: caller.UserID,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]FollowRequest, 0, len(requests))
+	for _, request := range requests {
+		response = append(response, FollowRequest{
+			FollowerID: request.FollowerID,
+			CreatedAt:  request.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerApproveFollowRequest(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	follower, ok := cfg.otherUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		accepted, err := q.AcceptFollowRequest(r.Context(), database.AcceptFollowRequestParams{
+			FollowerID: follower.ID,
+			FolloweeID: caller.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		if accepted == 0 {
+			return sql.ErrNoRows
+		}
+
+		return publishEvent(r.Context(), q, webhooks.EventUserFollowed, FollowEvent{
+			UserID:     caller.UserID,
+			FollowerID: follower.ID,
+		})
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Follow request not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't approve follow request", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerRejectFollowRequest(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	follower, ok := cfg.otherUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	rejected, err := cfg.DB.RejectFollowRequest(r.Context(), database.RejectFollowRequestParams{
+		FollowerID: follower.ID,
+		FolloweeID: caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't reject follow request", err)
+		return
+	}
+	if rejected == 0 {
+		respondWithError(w, http.StatusNotFound, "Follow request not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// otherUserFromPath loads the user in the path, which can't be the caller.
+func (cfg *apiConfig) otherUserFromPath(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	caller, _ := principalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return database.User{}, false
+	}
+
+	if userID == caller.UserID {
+		respondWithError(w, http.StatusBadRequest, "Can't do that to yourself", nil)
+		return database.User{}, false
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return database.User{}, false
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return database.User{}, false
+	}
+
+	return user, true
+}
+
+// canMessage reports whether sender is allowed to message recipient. Blocks
+// always apply, the recipient's DM policy only when starting a conversation.
+func canMessage(ctx context.Context, q *database.Queries, sender uuid.UUID, recipient database.User, starting bool) (bool, error) {
+	blocked, err := q.IsBlockedEitherWay(ctx, database.IsBlockedEitherWayParams{
+		BlockerID: sender,
+		BlockedID: recipient.ID,
+	})
+	if err != nil || blocked {
+		return false, err
+	}
+
+	if !starting || recipient.DmPolicy != dmPolicyFollowers {
+		return true, nil
+	}
+
+	// "Followers" are the people following the recipient
+	return q.IsFollowing(ctx, database.IsFollowingParams{
+		FollowerID: sender,
+		FolloweeID: recipient.ID,
+	})
+}
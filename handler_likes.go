@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	// Only chirps the caller can read can be liked
+	chirp, err := cfg.DB.GetVisibleChirp(r.Context(), database.GetVisibleChirpParams{
+		ID:       chirpID,
+		ViewerID: uuid.NullUUID{UUID: caller.UserID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	blocked, err := cfg.DB.IsBlockedEitherWay(r.Context(), database.IsBlockedEitherWayParams{
+		BlockerID: chirp.UserID,
+		BlockedID: caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	} else if blocked {
+		respondWithError(w, http.StatusForbidden, "Forbidden: can't like this chirp", nil)
+		return
+	}
+
+	// Liking a chirp again doesn't notify its author again
+	err = cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		liked, err := q.LikeChirp(r.Context(), database.LikeChirpParams{
+			ChirpID: chirp.ID,
+			UserID:  caller.UserID,
+		})
+		if err != nil || liked == 0 {
+			return err
+		}
+
+		return publishEvent(r.Context(), q, eventChirpLiked, ChirpLikedEvent{
+			UserID:  chirp.UserID,
+			ChirpID: chirp.ID,
+			LikerID: caller.UserID,
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't like chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	unliked, err := cfg.DB.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		ChirpID: chirpID,
+		UserID:  caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't unlike chirp", err)
+		return
+	}
+	if unliked == 0 {
+		respondWithError(w, http.StatusNotFound, "Like not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
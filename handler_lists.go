@@ -0,0 +1,419 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/chirptext"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/views"
+)
+
+const (
+	maxListNameLength = 50
+	maxListMembers    = 500
+)
+
+type List struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	OwnerID   uuid.UUID `json:"owner_id"`
+	Name      string    `json:"name"`
+	Private   bool      `json:"private"`
+}
+
+type ListMember struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Lists are public unless private is set, updates that leave it out keep
+// the current value.
+type listParameters struct {
+	Name    string `json:"name"`
+	Private *bool  `json:"private"`
+}
+
+func (cfg *apiConfig) handlerCreateList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	params := listParameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "The request body isn't valid JSON", err)
+		return
+	}
+
+	v := &validator{}
+	validateListName(v, &params)
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
+		return
+	}
+
+	list, err := cfg.DB.CreateList(r.Context(), database.CreateListParams{
+		OwnerID:   caller.UserID,
+		Name:      params.Name,
+		IsPrivate: params.Private != nil && *params.Private,
+	})
+	if isUniqueConstraintError(err) {
+		v.add("name", fieldInvalid, "You already have a list with this name")
+		respondWithValidationProblem(w, v)
+		return
+	} else if err != nil {
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't create list", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, mapList(list))
+}
+
+// handlerListLists returns the caller's lists and the public lists they
+// subscribed to.
+func (cfg *apiConfig) handlerListLists(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	lists, err := cfg.DB.GetUserLists(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]List, 0, len(lists))
+	for _, list := range lists {
+		response = append(response, mapList(list))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerGetList(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.readableListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapList(list))
+}
+
+// handlerUpdateList renames a list or changes its privacy. Making a list
+// private drops its subscribers.
+func (cfg *apiConfig) handlerUpdateList(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.ownListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	params := listParameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "The request body isn't valid JSON", err)
+		return
+	}
+
+	v := &validator{}
+	validateListName(v, &params)
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
+		return
+	}
+
+	update := database.UpdateListParams{
+		ID:        list.ID,
+		Name:      params.Name,
+		IsPrivate: list.IsPrivate,
+	}
+	if params.Private != nil {
+		update.IsPrivate = *params.Private
+	}
+
+	var updated database.List
+	err := cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		updated, err = q.UpdateList(r.Context(), update)
+		if err != nil {
+			return err
+		}
+
+		if updated.IsPrivate && !list.IsPrivate {
+			return q.DeleteListSubscriptions(r.Context(), list.ID)
+		}
+		return nil
+	})
+	if isUniqueConstraintError(err) {
+		v.add("name", fieldInvalid, "You already have a list with this name")
+		respondWithValidationProblem(w, v)
+		return
+	} else if err != nil {
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't update list", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapList(updated))
+}
+
+func (cfg *apiConfig) handlerDeleteList(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.ownListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.DeleteList(r.Context(), list.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't delete list", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerListListMembers(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.readableListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	members, err := cfg.DB.ListListMembers(r.Context(), database.ListListMembersParams{
+		ListID:      list.ID,
+		LimitCount:  limit,
+		OffsetCount: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]ListMember, 0, len(members))
+	for _, member := range members {
+		response = append(response, ListMember{
+			UserID:    member.UserID,
+			CreatedAt: member.CreatedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerAddListMember(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+
+	list, ok := cfg.ownListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		return
+	}
+
+	count, err := cfg.DB.CountListMembers(r.Context(), list.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+	if count >= maxListMembers {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Lists can have at most %d members", maxListMembers), nil)
+		return
+	}
+
+	err = cfg.DB.AddListMember(r.Context(), database.AddListMemberParams{
+		ListID: list.ID,
+		UserID: params.UserID,
+	})
+	if isForeignKeyError(err) {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't add list member", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerRemoveListMember(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.ownListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	removed, err := cfg.DB.RemoveListMember(r.Context(), database.RemoveListMemberParams{
+		ListID: list.ID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't remove list member", err)
+		return
+	}
+	if removed == 0 {
+		respondWithError(w, http.StatusNotFound, "User isn't a member of the list", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerSubscribeToList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	list, ok := cfg.readableListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if list.OwnerID == caller.UserID {
+		respondWithError(w, http.StatusBadRequest, "Can't subscribe to your own list", nil)
+		return
+	}
+
+	if err := cfg.DB.SubscribeToList(r.Context(), database.SubscribeToListParams{
+		ListID: list.ID,
+		UserID: caller.UserID,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't subscribe to list", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerUnsubscribeFromList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	listID, err := uuid.Parse(r.PathValue("listID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid list ID", err)
+		return
+	}
+
+	unsubscribed, err := cfg.DB.UnsubscribeFromList(r.Context(), database.UnsubscribeFromListParams{
+		ListID: listID,
+		UserID: caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't unsubscribe from list", err)
+		return
+	}
+	if unsubscribed == 0 {
+		respondWithError(w, http.StatusNotFound, "Not subscribed to this list", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerGetListChirps is the timeline of a list: the chirps of its members
+// the viewer can read, oldest first unless sort=desc.
+func (cfg *apiConfig) handlerGetListChirps(w http.ResponseWriter, r *http.Request) {
+	list, ok := cfg.readableListFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	chirps, err := cfg.DB.GetListChirps(r.Context(), database.GetListChirpsParams{
+		ListID:      list.ID,
+		ViewerID:    viewerFromContext(r.Context()),
+		NewestFirst: r.URL.Query().Get("sort") == "desc",
+		LimitCount:  limit,
+		OffsetCount: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := mapChirps(chirps)
+	cfg.attachLinkPreviews(r.Context(), response)
+	cfg.recordViews(r, views.Impression, response...)
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func validateListName(v *validator, params *listParameters) {
+	params.Name = strings.TrimSpace(chirptext.Normalize(params.Name))
+
+	if chirptext.IsBlank(params.Name) {
+		v.add("name", fieldRequired, "List name can't be empty")
+	} else if length := chirptext.Length(params.Name); length > maxListNameLength {
+		v.add("name", fieldTooLong, fmt.Sprintf("List name is %d characters long, it can have at most %d", length, maxListNameLength))
+	}
+}
+
+// readableListFromPath loads the list in the path. Private lists don't exist
+// for anyone but their owner.
+func (cfg *apiConfig) readableListFromPath(w http.ResponseWriter, r *http.Request) (database.List, bool) {
+	listID, err := uuid.Parse(r.PathValue("listID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid list ID", err)
+		return database.List{}, false
+	}
+
+	list, err := cfg.DB.GetList(r.Context(), listID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "List not found", nil)
+		return database.List{}, false
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return database.List{}, false
+	}
+
+	viewer := viewerFromContext(r.Context())
+	if list.IsPrivate && (!viewer.Valid || viewer.UUID != list.OwnerID) {
+		respondWithError(w, http.StatusNotFound, "List not found", nil)
+		return database.List{}, false
+	}
+
+	return list, true
+}
+
+// ownListFromPath loads the list in the path and checks the caller owns it.
+func (cfg *apiConfig) ownListFromPath(w http.ResponseWriter, r *http.Request) (database.List, bool) {
+	caller, _ := principalFromContext(r.Context())
+
+	list, ok := cfg.readableListFromPath(w, r)
+	if !ok {
+		return database.List{}, false
+	}
+
+	if list.OwnerID != caller.UserID {
+		respondWithError(w, http.StatusForbidden, "Forbidden: can't change lists from other users!", nil)
+		return database.List{}, false
+	}
+
+	return list, true
+}
+
+func mapList(list database.List) List {
+	return List{
+		ID:        list.ID,
+		CreatedAt: list.CreatedAt,
+		UpdatedAt: list.UpdatedAt,
+		OwnerID:   list.OwnerID,
+		Name:      list.Name,
+		Private:   list.IsPrivate,
+	}
+}
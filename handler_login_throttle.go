@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+type loginThrottleKey struct {
+	key    string
+	policy auth.ThrottlePolicy
+}
+
+// loginThrottleKeys returns the keys failed logins are tracked under: the
+// account (by email, whether it exists or not) and the client address.
+func (cfg *apiConfig) loginThrottleKeys(r *http.Request, email string) []loginThrottleKey {
+	return []loginThrottleKey{
+		{key: accountThrottleKey(email), policy: cfg.AccountThrottle},
+		{key: "ip:" + clientIP(r), policy: cfg.IPThrottle},
+	}
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// clientIP uses the address of the connection, forwarding headers can be
+// set by anyone and would let an attacker pick their own key.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+var errInvalidCredentials = errors.New("incorrect email or password")
+
+// checkLogin checks the credentials, unless the client has to wait before
+// trying again and it returns how long. The throttle keys are locked while
+// it runs, so parallel attempts are checked one after the other and each
+// one sees the failures recorded by the previous ones. Keys are always
+// locked in the same order.
+func (cfg *apiConfig) checkLogin(ctx context.Context, keys []loginThrottleKey, email, password string) (database.User, time.Duration, error) {
+	var user database.User
+	var wait time.Duration
+	failed := false
+
+	err := cfg.DB.InTx(ctx, func(q *database.Queries) error {
+		for _, k := range keys {
+			if err := q.LockLoginThrottle(ctx, k.key); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		wait, err = loginRetryAfter(ctx, q, keys)
+		if err != nil || wait > 0 {
+			return err
+		}
+
+		user, err = q.GetUserByEmail(ctx, email)
+		if err == nil {
+			err = auth.CheckPasswordHash(password, user.HashedPassword)
+		} else {
+			// Take as long as a wrong password would
+			auth.CheckDummyPassword(password)
+		}
+
+		if err != nil {
+			failed = true
+			return recordLoginFailure(ctx, q, keys)
+		}
+
+		return q.ClearLoginThrottle(ctx, accountThrottleKey(email))
+	})
+	if err != nil {
+		return database.User{}, 0, err
+	}
+	if failed {
+		return database.User{}, 0, errInvalidCredentials
+	}
+
+	return user, wait, nil
+}
+
+// loginRetryAfter returns how long the client has to wait before trying to
+// log in again, zero if it can try now.
+func loginRetryAfter(ctx context.Context, q *database.Queries, keys []loginThrottleKey) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now().UTC()
+
+	for _, k := range keys {
+		throttle, err := q.GetLoginThrottle(ctx, k.key)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		if throttle.LastFailureAt.Before(k.policy.ResetCutoff(now)) {
+			continue
+		}
+
+		retryAt := k.policy.RetryAt(int(throttle.Failures), throttle.LastFailureAt)
+		wait = max(wait, retryAt.Sub(now))
+	}
+
+	return wait, nil
+}
+
+func recordLoginFailure(ctx context.Context, q *database.Queries, keys []loginThrottleKey) error {
+	now := time.Now().UTC()
+
+	for _, k := range keys {
+		if _, err := q.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+			Key:           k.key,
+			LastFailureAt: k.policy.ResetCutoff(now),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func respondWithRetryAfter(w http.ResponseWriter, wait time.Duration, msg string) {
+	setRetryAfter(w, wait)
+	respondWithError(w, http.StatusTooManyRequests, msg, nil)
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+func (cfg *apiConfig) handlerUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	if err := cfg.DB.ClearLoginThrottle(r.Context(), accountThrottleKey(user.Email)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't unlock user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
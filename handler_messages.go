@@ -0,0 +1,379 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+const (
+	maxConversationMembers = 10
+	maxMessageLength       = 2000
+)
+
+const eventMessageCreated = "message.created"
+
+type Conversation struct {
+	ID          uuid.UUID   `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	IsGroup     bool        `json:"is_group"`
+	MemberIDs   []uuid.UUID `json:"member_ids"`
+	UnreadCount int64       `json:"unread_count"`
+}
+
+type Message struct {
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	Body           string    `json:"body"`
+}
+
+// messageEvent is published when a message is sent. It doesn't include the
+// body, which must not be stored unencrypted.
+type messageEvent struct {
+	ID             uuid.UUID   `json:"id"`
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	SenderID       uuid.UUID   `json:"sender_id"`
+	MemberIDs      []uuid.UUID `json:"member_ids"`
+}
+
+// handlerCreateConversation starts a conversation with one or more users.
+// Starting a 1:1 conversation that already exists returns it.
+func (cfg *apiConfig) handlerCreateConversation(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MemberIDs []uuid.UUID `json:"member_ids"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	params := parameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		return
+	}
+
+	var memberIDs []uuid.UUID
+	for _, id := range params.MemberIDs {
+		if id != caller.UserID && !slices.Contains(memberIDs, id) {
+			memberIDs = append(memberIDs, id)
+		}
+	}
+	if len(memberIDs) == 0 || len(memberIDs) >= maxConversationMembers {
+		respondWithError(w, http.StatusBadRequest, "Conversations must have between 2 and 10 members", nil)
+		return
+	}
+
+	for _, id := range memberIDs {
+		member, err := cfg.DB.GetUserByID(r.Context(), id)
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, "User not found: "+id.String(), nil)
+			return
+		} else if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+			return
+		}
+
+		allowed, err := canMessage(r.Context(), cfg.DB.Queries, caller.UserID, member, true)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+			return
+		} else if !allowed {
+			respondWithError(w, http.StatusForbidden, "Forbidden: you can't message "+id.String(), nil)
+			return
+		}
+	}
+
+	if len(memberIDs) == 1 {
+		conversation, err := cfg.DB.GetDirectConversation(r.Context(), database.GetDirectConversationParams{
+			UserID:      caller.UserID,
+			OtherUserID: memberIDs[0],
+		})
+		if err == nil {
+			cfg.respondWithConversation(w, r, http.StatusOK, conversation, 0)
+			return
+		} else if err != sql.ErrNoRows {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+			return
+		}
+	}
+
+	var conversation database.Conversation
+	err := cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		conversation, err = q.CreateConversation(r.Context(), len(memberIDs) > 1)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range append([]uuid.UUID{caller.UserID}, memberIDs...) {
+			if err := q.AddConversationMember(r.Context(), database.AddConversationMemberParams{
+				ConversationID: conversation.ID,
+				UserID:         id,
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't create conversation", err)
+		return
+	}
+
+	cfg.respondWithConversation(w, r, http.StatusCreated, conversation, 0)
+}
+
+func (cfg *apiConfig) handlerListConversations(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	conversations, err := cfg.DB.ListConversations(r.Context(), database.ListConversationsParams{
+		UserID:      caller.UserID,
+		LimitCount:  limit,
+		OffsetCount: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]Conversation, 0, len(conversations))
+	for _, conversation := range conversations {
+		memberIDs, err := cfg.DB.ListConversationMembers(r.Context(), conversation.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+			return
+		}
+
+		response = append(response, Conversation{
+			ID:          conversation.ID,
+			CreatedAt:   conversation.CreatedAt,
+			UpdatedAt:   conversation.UpdatedAt,
+			IsGroup:     conversation.IsGroup,
+			MemberIDs:   memberIDs,
+			UnreadCount: conversation.UnreadCount,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerSendMessage(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	conversation, ok := cfg.conversationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		return
+	}
+
+	if strings.TrimSpace(params.Body) == "" || utf8.RuneCountInString(params.Body) > maxMessageLength {
+		respondWithError(w, http.StatusBadRequest, "Messages must be between 1 and 2000 characters", nil)
+		return
+	}
+
+	memberIDs, err := cfg.DB.ListConversationMembers(r.Context(), conversation.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	for _, id := range memberIDs {
+		if id == caller.UserID {
+			continue
+		}
+
+		member, err := cfg.DB.GetUserByID(r.Context(), id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+			return
+		}
+
+		allowed, err := canMessage(r.Context(), cfg.DB.Queries, caller.UserID, member, false)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+			return
+		} else if !allowed {
+			respondWithError(w, http.StatusForbidden, "Forbidden: you can't message this conversation", nil)
+			return
+		}
+	}
+
+	messageID := uuid.New()
+	encrypted, err := cfg.MessageCipher.Encrypt([]byte(params.Body), messageAdditionalData(conversation.ID, messageID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error: couldn't encrypt message", err)
+		return
+	}
+
+	var message database.Message
+	err = cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		message, err = q.CreateMessage(r.Context(), database.CreateMessageParams{
+			ID:             messageID,
+			ConversationID: conversation.ID,
+			SenderID:       caller.UserID,
+			BodyEncrypted:  encrypted,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := q.TouchConversation(r.Context(), conversation.ID); err != nil {
+			return err
+		}
+
+		return publishEvent(r.Context(), q, eventMessageCreated, messageEvent{
+			ID:             message.ID,
+			ConversationID: conversation.ID,
+			SenderID:       caller.UserID,
+			MemberIDs:      memberIDs,
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't send message", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, mapMessage(message, params.Body))
+}
+
+// handlerListMessages returns the newest messages first.
+func (cfg *apiConfig) handlerListMessages(w http.ResponseWriter, r *http.Request) {
+	conversation, ok := cfg.conversationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	messages, err := cfg.DB.ListMessages(r.Context(), database.ListMessagesParams{
+		ConversationID: conversation.ID,
+		LimitCount:     limit,
+		OffsetCount:    offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		body, err := cfg.MessageCipher.Decrypt(message.BodyEncrypted, messageAdditionalData(message.ConversationID, message.ID))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error: couldn't decrypt message", err)
+			return
+		}
+
+		response = append(response, mapMessage(message, string(body)))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerMarkConversationRead marks every message in the conversation as
+// read by the caller.
+func (cfg *apiConfig) handlerMarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	conversation, ok := cfg.conversationFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.MarkConversationRead(r.Context(), database.MarkConversationReadParams{
+		ConversationID: conversation.ID,
+		UserID:         caller.UserID,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update conversation", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// conversationFromPath loads the conversation in the path. Conversations
+// the caller isn't a member of are reported as not found.
+func (cfg *apiConfig) conversationFromPath(w http.ResponseWriter, r *http.Request) (database.Conversation, bool) {
+	caller, _ := principalFromContext(r.Context())
+
+	conversationID, err := uuid.Parse(r.PathValue("conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversation ID", err)
+		return database.Conversation{}, false
+	}
+
+	conversation, err := cfg.DB.GetConversationForMember(r.Context(), database.GetConversationForMemberParams{
+		ID:     conversationID,
+		UserID: caller.UserID,
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Conversation not found", nil)
+		return database.Conversation{}, false
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return database.Conversation{}, false
+	}
+
+	return conversation, true
+}
+
+func (cfg *apiConfig) respondWithConversation(w http.ResponseWriter, r *http.Request, code int, conversation database.Conversation, unread int64) {
+	memberIDs, err := cfg.DB.ListConversationMembers(r.Context(), conversation.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	respondWithJSON(w, code, Conversation{
+		ID:          conversation.ID,
+		CreatedAt:   conversation.CreatedAt,
+		UpdatedAt:   conversation.UpdatedAt,
+		IsGroup:     conversation.IsGroup,
+		MemberIDs:   memberIDs,
+		UnreadCount: unread,
+	})
+}
+
+// messageAdditionalData binds an encrypted body to its message, so it can't
+// be copied to another row.
+func messageAdditionalData(conversationID, messageID uuid.UUID) []byte {
+	return []byte(conversationID.String() + ":" + messageID.String())
+}
+
+func mapMessage(message database.Message, body string) Message {
+	return Message{
+		ID:             message.ID,
+		CreatedAt:      message.CreatedAt,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		Body:           body,
+	}
+}
@@ -14,10 +14,11 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 	  <body>
 	    <h1>Welcome, Chirpy Admin</h1>
 	    <p>Chirpy has been visited %d times!</p>
+	    <p>Open real-time connections: %d WebSocket, %d Server-Sent Events</p>
 	  </body>
 	</html>
-	`, cfg.fileserverHits.Load())
-	w.Write([]byte(fmt.Sprintf(body, cfg.fileserverHits.Load())))
+	`, cfg.fileserverHits.Load(), cfg.websocketConnections.Load(), cfg.streamConnections.Load())
+	w.Write([]byte(body))
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
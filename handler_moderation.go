@@ -0,0 +1,296 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/contentfilter"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+type FilterRule struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Term      string    `json:"term"`
+	Action    string    `json:"action"`
+}
+
+type ChirpFlag struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ChirpID    uuid.UUID  `json:"chirp_id"`
+	RuleID     *uuid.UUID `json:"rule_id"`
+	Term       string     `json:"term"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	ResolvedBy *uuid.UUID `json:"resolved_by"`
+}
+
+type filterRuleParameters struct {
+	Term   string `json:"term"`
+	Action string `json:"action"`
+}
+
+func (p *filterRuleParameters) validate() string {
+	p.Term = strings.TrimSpace(p.Term)
+	if p.Term == "" {
+		return "Term is required"
+	}
+	if !contentfilter.Action(p.Action).Valid() {
+		return "Action must be mask, reject or flag"
+	}
+
+	return ""
+}
+
+func (cfg *apiConfig) handlerListFilterRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := cfg.DB.ListFilterRules(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]FilterRule, 0, len(rules))
+	for _, rule := range rules {
+		response = append(response, mapFilterRule(rule))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerCreateFilterRule(w http.ResponseWriter, r *http.Request) {
+	params := filterRuleParameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if msg := params.validate(); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	rule, err := cfg.DB.CreateFilterRule(r.Context(), database.CreateFilterRuleParams{
+		Term:   params.Term,
+		Action: params.Action,
+	})
+	if isUniqueConstraintError(err) {
+		respondWithError(w, http.StatusConflict, "A rule for this term already exists", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't create filter rule", err)
+		return
+	}
+
+	cfg.reloadContentFilterAfterChange(r)
+	respondWithJSON(w, http.StatusCreated, mapFilterRule(rule))
+}
+
+func (cfg *apiConfig) handlerUpdateFilterRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := uuid.Parse(r.PathValue("ruleID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rule ID", err)
+		return
+	}
+
+	params := filterRuleParameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	if msg := params.validate(); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	rule, err := cfg.DB.UpdateFilterRule(r.Context(), database.UpdateFilterRuleParams{
+		ID:     ruleID,
+		Term:   params.Term,
+		Action: params.Action,
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Filter rule not found", nil)
+		return
+	} else if isUniqueConstraintError(err) {
+		respondWithError(w, http.StatusConflict, "A rule for this term already exists", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update filter rule", err)
+		return
+	}
+
+	cfg.reloadContentFilterAfterChange(r)
+	respondWithJSON(w, http.StatusOK, mapFilterRule(rule))
+}
+
+func (cfg *apiConfig) handlerDeleteFilterRule(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := uuid.Parse(r.PathValue("ruleID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rule ID", err)
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteFilterRule(r.Context(), ruleID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't delete filter rule", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Filter rule not found", nil)
+		return
+	}
+
+	cfg.reloadContentFilterAfterChange(r)
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// reloadContentFilterAfterChange applies a rule change on this instance
+// right away, the other instances reload when they are notified.
+func (cfg *apiConfig) reloadContentFilterAfterChange(r *http.Request) {
+	if err := cfg.reloadContentFilter(r.Context()); err != nil {
+		log.Printf("Couldn't reload content filter: %v", err)
+	}
+}
+
+func (cfg *apiConfig) handlerListChirpFlags(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	flags, err := cfg.DB.ListChirpFlags(r.Context(), database.ListChirpFlagsParams{
+		UnresolvedOnly: r.URL.Query().Get("status") != "all",
+		LimitCount:     limit,
+		OffsetCount:    offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]ChirpFlag, 0, len(flags))
+	for _, flag := range flags {
+		response = append(response, mapChirpFlag(flag))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerResolveChirpFlag(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	flagID, err := uuid.Parse(r.PathValue("flagID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid flag ID", err)
+		return
+	}
+
+	flag, err := cfg.DB.ResolveChirpFlag(r.Context(), database.ResolveChirpFlagParams{
+		ID:         flagID,
+		ResolvedBy: uuid.NullUUID{UUID: caller.UserID, Valid: true},
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Flag not found or already resolved", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't resolve flag", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapChirpFlag(flag))
+}
+
+// handlerApplyContentWarning sets the content warning of any chirp. The
+// author can't remove a warning applied this way.
+func (cfg *apiConfig) handlerApplyContentWarning(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentWarning *string `json:"content_warning"`
+		Sensitive      bool    `json:"sensitive"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	params := parameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	v := &validator{}
+	contentWarning := validateContentWarning(v, params.ContentWarning)
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
+		return
+	}
+
+	// Clearing the warning gives control back to the author
+	appliedBy := uuid.NullUUID{UUID: caller.UserID, Valid: true}
+	if !contentWarning.Valid && !params.Sensitive {
+		appliedBy = uuid.NullUUID{}
+	}
+
+	var chirp database.Chirp
+	err = cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		chirp, err = q.ApplyContentWarning(r.Context(), database.ApplyContentWarningParams{
+			ID:               chirpID,
+			ContentWarning:   contentWarning,
+			Sensitive:        params.Sensitive,
+			WarningAppliedBy: appliedBy,
+		})
+		if err != nil {
+			return err
+		}
+
+		return publishEvent(r.Context(), q, eventChirpUpdated, mapChirp(chirp))
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't apply content warning", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.mapChirpWithPreviews(r.Context(), chirp))
+}
+
+func mapFilterRule(rule database.FilterRule) FilterRule {
+	return FilterRule{
+		ID:        rule.ID,
+		CreatedAt: rule.CreatedAt,
+		UpdatedAt: rule.UpdatedAt,
+		Term:      rule.Term,
+		Action:    rule.Action,
+	}
+}
+
+func mapChirpFlag(flag database.ChirpFlag) ChirpFlag {
+	response := ChirpFlag{
+		ID:         flag.ID,
+		CreatedAt:  flag.CreatedAt,
+		ChirpID:    flag.ChirpID,
+		Term:       flag.Term,
+		ResolvedAt: nullTimePtr(flag.ResolvedAt),
+	}
+	if flag.RuleID.Valid {
+		response.RuleID = &flag.RuleID.UUID
+	}
+	if flag.ResolvedBy.Valid {
+		response.ResolvedBy = &flag.ResolvedBy.UUID
+	}
+
+	return response
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+// Only the most recent actors are listed, actor_count has the total
+const maxNotificationActors = 3
+
+type Notification struct {
+	ID         uuid.UUID   `json:"id"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	UserID     uuid.UUID   `json:"user_id"`
+	Type       string      `json:"type"`
+	Summary    string      `json:"summary"`
+	TargetID   *uuid.UUID  `json:"target_id"`
+	ActorIDs   []uuid.UUID `json:"actor_ids"`
+	ActorCount int32       `json:"actor_count"`
+	Read       bool        `json:"read"`
+}
+
+func (cfg *apiConfig) handlerListNotifications(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	unreadOnly := false
+	if raw := r.URL.Query().Get("unread"); raw != "" {
+		var err error
+		unreadOnly, err = strconv.ParseBool(raw)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "unread must be true or false", nil)
+			return
+		}
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	notifications, err := cfg.DB.ListNotifications(r.Context(), database.ListNotificationsParams{
+		UserID:      caller.UserID,
+		UnreadOnly:  unreadOnly,
+		LimitCount:  limit,
+		OffsetCount: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]Notification, 0, len(notifications))
+	for _, notification := range notifications {
+		response = append(response, mapNotification(notification))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	notificationID, err := uuid.Parse(r.PathValue("notificationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid notification ID", err)
+		return
+	}
+
+	// Marking a notification that's already read is a no-op
+	if _, err := cfg.DB.MarkNotificationRead(r.Context(), database.MarkNotificationReadParams{
+		ID:     notificationID,
+		UserID: caller.UserID,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update notification", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	if _, err := cfg.DB.MarkAllNotificationsRead(r.Context(), caller.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update notifications", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerGetNotificationPreferences returns whether each type of
+// notification is enabled. They are all enabled by default.
+func (cfg *apiConfig) handlerGetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	preferences, err := cfg.DB.ListNotificationPreferences(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapNotificationPreferences(preferences))
+}
+
+// handlerUpdateNotificationPreferences only changes the types in the request.
+func (cfg *apiConfig) handlerUpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	params := map[string]bool{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		return
+	}
+
+	for notificationType := range params {
+		if !slices.Contains(notificationTypes, notificationType) {
+			respondWithError(w, http.StatusBadRequest, "Unknown notification type: "+notificationType, nil)
+			return
+		}
+	}
+
+	var preferences []database.NotificationPreference
+	err := cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		for notificationType, enabled := range params {
+			if err := q.SetNotificationPreference(r.Context(), database.SetNotificationPreferenceParams{
+				UserID:  caller.UserID,
+				Type:    notificationType,
+				Enabled: enabled,
+			}); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		preferences, err = q.ListNotificationPreferences(r.Context(), caller.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update preferences", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapNotificationPreferences(preferences))
+}
+
+func mapNotification(notification database.Notification) Notification {
+	response := Notification{
+		ID:         notification.ID,
+		CreatedAt:  notification.CreatedAt,
+		UpdatedAt:  notification.UpdatedAt,
+		UserID:     notification.UserID,
+		Type:       notification.Type,
+		Summary:    notificationSummary(notification.Type, notification.ActorCount),
+		ActorIDs:   notification.ActorIds[:min(len(notification.ActorIds), maxNotificationActors)],
+		ActorCount: notification.ActorCount,
+		Read:       notification.ReadAt.Valid,
+	}
+	if notification.TargetID.Valid {
+		response.TargetID = &notification.TargetID.UUID
+	}
+
+	return response
+}
+
+func mapNotificationPreferences(preferences []database.NotificationPreference) map[string]bool {
+	response := make(map[string]bool, len(notificationTypes))
+	for _, notificationType := range notificationTypes {
+		response[notificationType] = true
+	}
+	for _, preference := range preferences {
+		response[preference.Type] = preference.Enabled
+	}
+
+	return response
+}
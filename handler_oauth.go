@@ -0,0 +1,484 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+const (
+	oauthConsentPage          = "/app/oauth/consent.html"
+	oauthAuthorizationCodeTTL = 10 * time.Minute
+)
+
+type authorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// oauthError is an error in the format of RFC 6749, section 5.2.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	// redirect is false when the client or redirect URI couldn't be
+	// verified, in which case the user must not be sent back to it.
+	redirect bool
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+type oauthIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// handlerOAuthAuthorize validates the authorization request and hands it
+// over to the consent page, which posts the user's decision back to
+// handlerOAuthConsent.
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := authorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	if _, _, oauthErr := cfg.checkAuthorizeRequest(r, req); oauthErr != nil {
+		if !oauthErr.redirect {
+			respondWithJSON(w, http.StatusBadRequest, oauthErr)
+			return
+		}
+		http.Redirect(w, r, authorizeRedirect(req, url.Values{
+			"error":             {oauthErr.Code},
+			"error_description": {oauthErr.Description},
+		}), http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, oauthConsentPage+"?"+r.URL.RawQuery, http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	type consentParams struct {
+		authorizeRequest
+		Approved bool `json:"approved"`
+	}
+	type consentResponse struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	params := consentParams{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	client, scopes, oauthErr := cfg.checkAuthorizeRequest(r, params.authorizeRequest)
+	if oauthErr != nil {
+		if !oauthErr.redirect {
+			respondWithJSON(w, http.StatusBadRequest, oauthErr)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, consentResponse{
+			RedirectTo: authorizeRedirect(params.authorizeRequest, url.Values{
+				"error":             {oauthErr.Code},
+				"error_description": {oauthErr.Description},
+			}),
+		})
+		return
+	}
+
+	if !params.Approved {
+		respondWithJSON(w, http.StatusOK, consentResponse{
+			RedirectTo: authorizeRedirect(params.authorizeRequest, url.Values{
+				"error": {"access_denied"},
+			}),
+		})
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating authorization code", err)
+		return
+	}
+
+	if err := cfg.DB.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      client.ID,
+		UserID:        caller.UserID,
+		RedirectUri:   params.RedirectURI,
+		Scopes:        auth.ScopeStrings(scopes),
+		CodeChallenge: params.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthAuthorizationCodeTTL),
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error storing authorization code in database", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, consentResponse{
+		RedirectTo: authorizeRedirect(params.authorizeRequest, url.Values{
+			"code": {code},
+		}),
+	})
+}
+
+// checkAuthorizeRequest validates an authorization request and returns the
+// client and the scopes being requested.
+func (cfg *apiConfig) checkAuthorizeRequest(r *http.Request, req authorizeRequest) (database.OauthClient, []auth.Scope, *oauthError) {
+	clientID, err := uuid.Parse(req.ClientID)
+	if err != nil {
+		return database.OauthClient{}, nil, &oauthError{Code: "invalid_request", Description: "invalid client_id"}
+	}
+
+	client, err := cfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, nil, &oauthError{Code: "invalid_request", Description: "unknown client"}
+	}
+
+	// Redirect URIs must match one of the registered ones exactly
+	if !slices.Contains(client.RedirectUris, req.RedirectURI) {
+		return database.OauthClient{}, nil, &oauthError{Code: "invalid_request", Description: "redirect_uri not registered for this client"}
+	}
+
+	if req.ResponseType != "code" {
+		return client, nil, &oauthError{Code: "unsupported_response_type", redirect: true}
+	}
+
+	if err := auth.ValidateCodeChallenge(req.CodeChallenge, req.CodeChallengeMethod); err != nil {
+		return client, nil, &oauthError{Code: "invalid_request", Description: err.Error(), redirect: true}
+	}
+
+	allowed, err := auth.ParseScopes(client.Scopes)
+	if err != nil {
+		return client, nil, &oauthError{Code: "server_error", redirect: true}
+	}
+
+	if req.Scope == "" {
+		return client, allowed, nil
+	}
+
+	scopes, err := auth.ParseScopeString(req.Scope)
+	if err != nil || !auth.ScopesSubset(scopes, allowed) {
+		return client, nil, &oauthError{Code: "invalid_scope", redirect: true}
+	}
+
+	return client, scopes, nil
+}
+
+func authorizeRedirect(req authorizeRequest, params url.Values) string {
+	redirectURI, _ := url.Parse(req.RedirectURI)
+	query := redirectURI.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+
+	redirectURI.RawQuery = query.Encode()
+	return redirectURI.String()
+}
+
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.exchangeOAuthRefreshToken(w, r, client)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{Code: "unsupported_grant_type"})
+	}
+}
+
+func (cfg *apiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	invalidGrant := &oauthError{Code: "invalid_grant", Description: "invalid, expired or already used authorization code"}
+
+	// Consuming the code marks it as used, so it can only be exchanged once.
+	// It is only consumed by the client it was issued to with the same
+	// redirect URI, anyone else holding it can't use it up.
+	code, err := cfg.DB.ConsumeOAuthAuthorizationCode(r.Context(), database.ConsumeOAuthAuthorizationCodeParams{
+		CodeHash:    auth.HashToken(r.PostForm.Get("code")),
+		ClientID:    client.ID,
+		RedirectUri: r.PostForm.Get("redirect_uri"),
+	})
+	if err == sql.ErrNoRows {
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	if !auth.VerifyCodeVerifier(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, &oauthError{Code: "invalid_grant", Description: "code_verifier doesn't match the code_challenge"})
+		return
+	}
+
+	scopes, err := auth.ParseScopes(code.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	cfg.issueOAuthTokens(w, r, client.ID, code.UserID, scopes)
+}
+
+func (cfg *apiConfig) exchangeOAuthRefreshToken(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	invalidGrant := &oauthError{Code: "invalid_grant", Description: "invalid or expired refresh token"}
+
+	grant, err := cfg.DB.GetOAuthTokenByRefreshHash(r.Context(), auth.HashToken(r.PostForm.Get("refresh_token")))
+	if err == sql.ErrNoRows {
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	if grant.ClientID != client.ID || grant.RevokedAt.Valid || time.Now().UTC().After(grant.RefreshExpiresAt) {
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+
+	scopes, err := auth.ParseScopes(grant.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	// The client may ask for a narrower set of scopes than the original grant
+	if requested := r.PostForm.Get("scope"); requested != "" {
+		narrowed, err := auth.ParseScopeString(requested)
+		if err != nil || !auth.ScopesSubset(narrowed, scopes) {
+			respondWithOAuthError(w, http.StatusBadRequest, &oauthError{Code: "invalid_scope"})
+			return
+		}
+		scopes = narrowed
+	}
+
+	// Refresh tokens are rotated, the old pair stops working. Only the
+	// request that revokes it gets new tokens, a refresh token used twice
+	// at the same time is only exchanged once.
+	revoked, err := cfg.DB.RevokeOAuthToken(r.Context(), grant.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't revoke token", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+
+	cfg.issueOAuthTokens(w, r, client.ID, grant.UserID, scopes)
+}
+
+func (cfg *apiConfig) issueOAuthTokens(w http.ResponseWriter, r *http.Request, clientID, userID uuid.UUID, scopes []auth.Scope) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating refresh token", err)
+		return
+	}
+
+	expiresIn := cfg.TokenPolicy.AccessTokenTTL
+	grant, err := cfg.DB.CreateOAuthToken(r.Context(), database.CreateOAuthTokenParams{
+		ClientID:         clientID,
+		UserID:           userID,
+		Scopes:           auth.ScopeStrings(scopes),
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		AccessExpiresAt:  time.Now().UTC().Add(expiresIn),
+		RefreshExpiresAt: cfg.TokenPolicy.RefreshTokenExpiry(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error storing token in database", err)
+		return
+	}
+
+	accessToken, err := auth.MakeDelegatedJWT(userID, cfg.SECRET, cfg.TokenPolicy, grant.ID, clientID, scopes, expiresIn)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error: couldn't create access token", err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(expiresIn.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        auth.FormatScopes(scopes),
+	})
+}
+
+// handlerOAuthRevoke implements token revocation (RFC 7009). Revoking either
+// token of a pair revokes both.
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+
+	grant, _, err := cfg.findOAuthToken(r, r.PostForm.Get("token"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	// Unknown tokens and tokens of other clients are ignored, the response
+	// is the same so it can't be used to probe for valid tokens
+	if err == nil && grant.ClientID == client.ID {
+		if _, err := cfg.DB.RevokeOAuthToken(r.Context(), grant.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Database error: couldn't revoke token", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerOAuthIntrospect implements token introspection (RFC 7662). Clients
+// can only introspect their own tokens.
+func (cfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	client, oauthErr := cfg.authenticateOAuthClient(r)
+	if oauthErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthErr)
+		return
+	}
+
+	grant, tokenType, err := cfg.findOAuthToken(r, r.PostForm.Get("token"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	expiresAt := grant.AccessExpiresAt
+	if tokenType == "refresh_token" {
+		expiresAt = grant.RefreshExpiresAt
+	}
+
+	if err != nil || grant.ClientID != client.ID || grant.RevokedAt.Valid || time.Now().UTC().After(expiresAt) {
+		respondWithJSON(w, http.StatusOK, oauthIntrospection{Active: false})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, oauthIntrospection{
+		Active:    true,
+		Scope:     auth.FormatScopes(scopesFromStrings(grant.Scopes)),
+		ClientID:  grant.ClientID.String(),
+		Subject:   grant.UserID.String(),
+		Issuer:    cfg.TokenPolicy.Issuer,
+		TokenType: tokenType,
+		ExpiresAt: expiresAt.Unix(),
+		IssuedAt:  grant.CreatedAt.Unix(),
+	})
+}
+
+// findOAuthToken looks up the grant of either a refresh token or an OAuth
+// access token and reports which one it was.
+func (cfg *apiConfig) findOAuthToken(r *http.Request, token string) (database.OauthToken, string, error) {
+	if token == "" {
+		return database.OauthToken{}, "", sql.ErrNoRows
+	}
+
+	grant, err := cfg.DB.GetOAuthTokenByRefreshHash(r.Context(), auth.HashToken(token))
+	if err == nil {
+		return grant, "refresh_token", nil
+	} else if err != sql.ErrNoRows {
+		return database.OauthToken{}, "", err
+	}
+
+	claims, err := auth.ParseJWT(token, cfg.SECRET, cfg.TokenPolicy)
+	if err != nil || !claims.Delegated() {
+		return database.OauthToken{}, "", sql.ErrNoRows
+	}
+
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return database.OauthToken{}, "", sql.ErrNoRows
+	}
+
+	grant, err = cfg.DB.GetOAuthToken(r.Context(), tokenID)
+	return grant, "access_token", err
+}
+
+// authenticateOAuthClient parses the form and authenticates the client with
+// HTTP Basic auth or the client_id/client_secret form parameters. Public
+// clients only send their client_id.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, *oauthError) {
+	invalidClient := &oauthError{Code: "invalid_client"}
+
+	if err := r.ParseForm(); err != nil {
+		return database.OauthClient{}, &oauthError{Code: "invalid_request"}
+	}
+
+	rawClientID, secret, ok := r.BasicAuth()
+	if !ok {
+		rawClientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(rawClientID)
+	if err != nil {
+		return database.OauthClient{}, invalidClient
+	}
+
+	client, err := cfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, invalidClient
+	}
+
+	if client.SecretHash.Valid && !auth.CheckClientSecret(secret, client.SecretHash.String) {
+		return database.OauthClient{}, invalidClient
+	}
+
+	return client, nil
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, oauthErr *oauthError) {
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, oauthErr)
+}
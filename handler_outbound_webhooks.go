@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
+)
+
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliveryDelivered = "delivered"
+	webhookDeliveryDead      = "dead"
+)
+
+type WebhookSubscription struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	URL       string     `json:"url"`
+	Events    []string   `json:"events"`
+	ClientID  *uuid.UUID `json:"client_id"`
+	// Secret is only returned when the subscription is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID            uuid.UUID                `json:"id"`
+	CreatedAt     time.Time                `json:"created_at"`
+	EventType     string                   `json:"event_type"`
+	Payload       json.RawMessage          `json:"payload"`
+	Status        string                   `json:"status"`
+	Attempts      int32                    `json:"attempts"`
+	NextAttemptAt *time.Time               `json:"next_attempt_at"`
+	DeliveredAt   *time.Time               `json:"delivered_at"`
+	Log           []WebhookDeliveryAttempt `json:"log,omitempty"`
+}
+
+type WebhookDeliveryAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int32     `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int32     `json:"duration_ms"`
+}
+
+func (cfg *apiConfig) handlerCreateWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	caller, _ := principalFromContext(r.Context())
+
+	params := parameters{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		return
+	}
+
+	if u, err := url.Parse(params.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		respondWithError(w, http.StatusBadRequest, "Webhook URL must be an http or https URL", nil)
+		return
+	}
+
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one event is required", nil)
+		return
+	}
+	events := make([]string, 0, len(params.Events))
+	for _, event := range params.Events {
+		if !webhooks.IsEvent(event) {
+			respondWithError(w, http.StatusBadRequest, "Unknown event: "+event, nil)
+			return
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+
+	clientID, err := cfg.callerClientID(r.Context(), caller)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error: couldn't generate webhook secret", err)
+		return
+	}
+
+	subscription, err := cfg.DB.CreateWebhookSubscription(r.Context(), database.CreateWebhookSubscriptionParams{
+		UserID:   caller.UserID,
+		ClientID: clientID,
+		Url:      params.URL,
+		Secret:   secret,
+		Events:   events,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't create webhook subscription", err)
+		return
+	}
+
+	response := mapWebhookSubscription(subscription)
+	response.Secret = secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerListWebhookSubscriptions(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	clientID, err := cfg.callerClientID(r.Context(), caller)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	subscriptions, err := cfg.DB.ListWebhookSubscriptions(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]WebhookSubscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		if canManageWebhookSubscription(clientID, subscription) {
+			response = append(response, mapWebhookSubscription(subscription))
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerDeleteWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	subscription, ok := cfg.ownedWebhookSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := cfg.DB.DeleteWebhookSubscription(r.Context(), database.DeleteWebhookSubscriptionParams{
+		ID:     subscription.ID,
+		UserID: caller.UserID,
+	}); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't delete webhook subscription", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := cfg.ownedWebhookSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", webhookDeliveryPending, webhookDeliveryDelivered, webhookDeliveryDead:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid status", nil)
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	deliveries, err := cfg.DB.ListWebhookDeliveries(r.Context(), database.ListWebhookDeliveriesParams{
+		SubscriptionID: subscription.ID,
+		Status:         status,
+		LimitCount:     limit,
+		OffsetCount:    offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		response = append(response, mapWebhookDelivery(delivery, nil))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerGetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := cfg.ownedWebhookDelivery(w, r)
+	if !ok {
+		return
+	}
+
+	attempts, err := cfg.DB.ListWebhookDeliveryAttempts(r.Context(), delivery.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapWebhookDelivery(delivery, attempts))
+}
+
+// handlerRedeliverWebhook schedules a dead-lettered delivery again, with a
+// fresh set of attempts.
+func (cfg *apiConfig) handlerRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := cfg.ownedWebhookDelivery(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := cfg.DB.RedeliverWebhookDelivery(r.Context(), delivery.ID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusConflict, "Only dead deliveries can be redelivered", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't schedule delivery", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, mapWebhookDelivery(delivery, nil))
+}
+
+// callerClientID returns the OAuth client the caller is acting for, if any.
+// Subscriptions created by an app belong to it, the user can manage all of
+// them.
+func (cfg *apiConfig) callerClientID(ctx context.Context, caller principal) (uuid.NullUUID, error) {
+	if !caller.OAuthTokenID.Valid {
+		return uuid.NullUUID{}, nil
+	}
+
+	grant, err := cfg.DB.GetOAuthToken(ctx, caller.OAuthTokenID.UUID)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+
+	return uuid.NullUUID{UUID: grant.ClientID, Valid: true}, nil
+}
+
+func canManageWebhookSubscription(clientID uuid.NullUUID, subscription database.WebhookSubscription) bool {
+	return !clientID.Valid || subscription.ClientID == clientID
+}
+
+// ownedWebhookSubscription loads the subscription in the path and responds
+// with 404 unless the caller can manage it.
+func (cfg *apiConfig) ownedWebhookSubscription(w http.ResponseWriter, r *http.Request) (database.WebhookSubscription, bool) {
+	caller, _ := principalFromContext(r.Context())
+
+	subscriptionID, err := uuid.Parse(r.PathValue("subscriptionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid subscription ID", err)
+		return database.WebhookSubscription{}, false
+	}
+
+	subscription, err := cfg.DB.GetWebhookSubscription(r.Context(), subscriptionID)
+	if err != nil && err != sql.ErrNoRows {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return database.WebhookSubscription{}, false
+	}
+
+	clientID, clientErr := cfg.callerClientID(r.Context(), caller)
+	if clientErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", clientErr)
+		return database.WebhookSubscription{}, false
+	}
+
+	if err == sql.ErrNoRows || subscription.UserID != caller.UserID || !canManageWebhookSubscription(clientID, subscription) {
+		respondWithError(w, http.StatusNotFound, "Webhook subscription not found", nil)
+		return database.WebhookSubscription{}, false
+	}
+
+	return subscription, true
+}
+
+func (cfg *apiConfig) ownedWebhookDelivery(w http.ResponseWriter, r *http.Request) (database.WebhookDelivery, bool) {
+	subscription, ok := cfg.ownedWebhookSubscription(w, r)
+	if !ok {
+		return database.WebhookDelivery{}, false
+	}
+
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID", err)
+		return database.WebhookDelivery{}, false
+	}
+
+	delivery, err := cfg.DB.GetWebhookDelivery(r.Context(), database.GetWebhookDeliveryParams{
+		ID:             deliveryID,
+		SubscriptionID: subscription.ID,
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Webhook delivery not found", nil)
+		return database.WebhookDelivery{}, false
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return database.WebhookDelivery{}, false
+	}
+
+	return delivery, true
+}
+
+func mapWebhookSubscription(subscription database.WebhookSubscription) WebhookSubscription {
+	response := WebhookSubscription{
+		ID:        subscription.ID,
+		CreatedAt: subscription.CreatedAt,
+		URL:       subscription.Url,
+		Events:    subscription.Events,
+	}
+	if subscription.ClientID.Valid {
+		response.ClientID = &subscription.ClientID.UUID
+	}
+
+	return response
+}
+
+func mapWebhookDelivery(delivery database.WebhookDelivery, attempts []database.WebhookDeliveryAttempt) WebhookDelivery {
+	response := WebhookDelivery{
+		ID:          delivery.ID,
+		CreatedAt:   delivery.CreatedAt,
+		EventType:   delivery.EventType,
+		Payload:     delivery.Payload,
+		Status:      delivery.Status,
+		Attempts:    delivery.Attempts,
+		DeliveredAt: nullTimePtr(delivery.DeliveredAt),
+	}
+	if delivery.Status == webhookDeliveryPending {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+
+	for _, attempt := range attempts {
+		response.Log = append(response.Log, WebhookDeliveryAttempt{
+			AttemptedAt: attempt.AttemptedAt,
+			StatusCode:  attempt.StatusCode.Int32,
+			Error:       attempt.Error.String,
+			DurationMS:  attempt.DurationMs,
+		})
+	}
+
+	return response
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/stream"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
+)
+
+const (
+	// Must match the channel used by the outbox trigger
+	streamChannel     = "outbox_events"
+	streamHeartbeat   = 15 * time.Second
+	streamBufferSize  = 64
+	streamReplayBatch = 500
+	// How long a gap in the outbox seqs is waited for. Seqs are taken when
+	// the event is inserted, not when it commits, so a gap is usually a
+	// transaction that is still running. Rolled back ones leave gaps for
+	// good.
+	streamGapGrace = 5 * time.Second
+	streamGapRetry = 250 * time.Millisecond
+)
+
+// handlerStream sends new chirps, deletions and the caller's notifications
+// as Server-Sent Events. The event ID is the outbox sequence number, so a
+// client that reconnects with Last-Event-ID gets the events it missed.
+func (cfg *apiConfig) handlerStream(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming not supported", nil)
+		return
+	}
+
+	cfg.streamConnections.Add(1)
+	defer cfg.streamConnections.Add(-1)
+
+	var lastSeq int64
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		var err error
+		lastSeq, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil || lastSeq < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID", nil)
+			return
+		}
+	}
+
+	// Subscribe before replaying so nothing is lost in between, events
+	// received twice are skipped by their seq
+	filter := streamFilter(caller)
+	sub := cfg.Stream.Subscribe(filter, streamBufferSize)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if lastSeq > 0 {
+		// Only events already published to the hub are replayed, the ones
+		// after them come through the subscription in seq order
+		until := cfg.streamPublishedSeq.Load()
+		if until == 0 {
+			until = math.MaxInt64
+		}
+
+		for {
+			events, err := cfg.DB.ListOutboxEventsAfter(r.Context(), database.ListOutboxEventsAfterParams{
+				AfterSeq:   lastSeq,
+				UntilSeq:   until,
+				LimitCount: streamReplayBatch,
+			})
+			if err != nil {
+				log.Printf("Couldn't replay stream events: %v", err)
+				return
+			}
+
+			for _, event := range events {
+				lastSeq = event.Seq
+				if e := streamEvent(event); filter(e) && cfg.streamEventVisible(r.Context(), caller.UserID, e) {
+					writeStreamEvent(w, e)
+				}
+			}
+			flusher.Flush()
+
+			if len(events) < streamReplayBatch {
+				break
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Fell too far behind, the client reconnects with Last-Event-ID
+				return
+			}
+			if event.Seq <= lastSeq {
+				continue
+			}
+			lastSeq = event.Seq
+			if !cfg.streamEventVisible(r.Context(), caller.UserID, event) {
+				continue
+			}
+			writeStreamEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// streamFilter decides which events are sent to caller. Everyone sees
+// chirps, notifications are only sent to the user they are for.
+func streamFilter(caller principal) func(stream.Event) bool {
+	return func(event stream.Event) bool {
+		switch event.Type {
+		case webhooks.EventChirpCreated, webhooks.EventChirpDeleted:
+			return true
+		case eventNotificationCreated:
+			if !caller.HasScope(auth.ScopeProfileRead) {
+				return false
+			}
+
+			var notification struct {
+				UserID uuid.UUID `json:"user_id"`
+			}
+			if err := json.Unmarshal(event.Payload, &notification); err != nil {
+				return false
+			}
+
+			return notification.UserID == caller.UserID
+		}
+
+		return false
+	}
+}
+
+// streamEventVisible reports whether viewer can read the chirp of a chirp
+// event, other events are left to the filters. It queries the database, so
+// it runs in the connection's goroutine and not in the hub filter.
+func (cfg *apiConfig) streamEventVisible(ctx context.Context, viewer uuid.UUID, event stream.Event) bool {
+	switch event.Type {
+	case webhooks.EventChirpCreated, eventChirpUpdated, webhooks.EventChirpDeleted:
+	default:
+		return true
+	}
+
+	chirp := Chirp{}
+	if err := json.Unmarshal(event.Payload, &chirp); err != nil {
+		return false
+	}
+
+	visible, err := cfg.DB.IsChirpVisible(ctx, database.IsChirpVisibleParams{
+		ChirpID:    chirp.ID,
+		AuthorID:   chirp.UserID,
+		Visibility: chirp.Visibility,
+		ViewerID:   uuid.NullUUID{UUID: viewer, Valid: true},
+	})
+	if err != nil {
+		log.Printf("Couldn't check chirp visibility: %v", err)
+		return false
+	}
+
+	return visible
+}
+
+// writeStreamEvent relies on the payload being compact JSON, which has no
+// line breaks.
+func writeStreamEvent(w http.ResponseWriter, event stream.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, event.Payload)
+}
+
+func streamEvent(event database.Outbox) stream.Event {
+	return stream.Event{
+		Seq:     event.Seq,
+		Type:    event.EventType,
+		Payload: event.Payload,
+	}
+}
+
+// listenForStreamEvents publishes new outbox events to the clients
+// connected to this instance. Every instance listens to the notifications
+// sent by the outbox trigger, so clients get events from all of them.
+func (cfg *apiConfig) listenForStreamEvents(ctx context.Context, dbURL string) {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Stream listener: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	for _, channel := range []string{streamChannel, typingChannel, filterRulesChannel} {
+		if err := listener.Listen(channel); err != nil {
+			log.Printf("Couldn't listen for stream events: %v", err)
+			return
+		}
+	}
+
+	var cursor streamCursor
+	for {
+		var err error
+		cursor.seq, err = cfg.DB.GetLatestOutboxSeq(ctx)
+		if err == nil {
+			cfg.streamPublishedSeq.Store(cursor.seq)
+			break
+		}
+		log.Printf("Couldn't load latest stream event: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retry:
+		case n := <-listener.Notify:
+			// A nil notification means the connection was reestablished,
+			// catching up handles both cases
+			if n != nil && n.Channel == typingChannel {
+				cfg.Stream.Publish(stream.Event{Type: eventTyping, Payload: json.RawMessage(n.Extra)})
+				continue
+			}
+			if n != nil && n.Channel == filterRulesChannel {
+				if err := cfg.reloadContentFilter(ctx); err != nil {
+					log.Printf("Couldn't reload content filter: %v", err)
+				}
+				continue
+			}
+		case <-time.After(time.Minute):
+			go listener.Ping()
+		}
+
+		retry = nil
+		if cfg.publishStreamEvents(ctx, &cursor) {
+			retry = time.After(streamGapRetry)
+		}
+	}
+}
+
+// streamCursor is the position of the publisher in the outbox.
+type streamCursor struct {
+	seq int64
+	// gapSince is when the publisher started waiting for the event after
+	// seq, zero if it isn't waiting
+	gapSince time.Time
+}
+
+// publishStreamEvents publishes the events after the cursor in seq order.
+// When a seq is missing it stops and reports that it is waiting for it,
+// the events after the gap are published once it is filled or after
+// streamGapGrace, so an event whose transaction commits after one with a
+// higher seq isn't skipped.
+func (cfg *apiConfig) publishStreamEvents(ctx context.Context, cursor *streamCursor) bool {
+	defer func() { cfg.streamPublishedSeq.Store(cursor.seq) }()
+
+	for {
+		events, err := cfg.DB.ListOutboxEventsAfter(ctx, database.ListOutboxEventsAfterParams{
+			AfterSeq:   cursor.seq,
+			UntilSeq:   math.MaxInt64,
+			LimitCount: streamReplayBatch,
+		})
+		if err != nil {
+			log.Printf("Couldn't load stream events: %v", err)
+			return false
+		}
+
+		for _, event := range events {
+			if event.Seq != cursor.seq+1 {
+				if cursor.gapSince.IsZero() {
+					cursor.gapSince = time.Now()
+				}
+				if time.Since(cursor.gapSince) < streamGapGrace {
+					return true
+				}
+			}
+
+			cfg.Stream.Publish(streamEvent(event))
+			cursor.seq = event.Seq
+			cursor.gapSince = time.Time{}
+		}
+
+		if len(events) < streamReplayBatch {
+			return false
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
@@ -17,13 +18,21 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := cfg.DB.GetUserFromRefreshToken(r.Context(), refreshToken)
+	userID, err := cfg.DB.GetUserFromRefreshToken(r.Context(), database.GetUserFromRefreshTokenParams{
+		Token:      refreshToken,
+		LastUsedAt: cfg.TokenPolicy.RefreshIdleCutoff(),
+	})
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Authorization error: Unauthorized", err)
 		return
 	}
 
-	token, err := auth.MakeJWT(userID, cfg.SECRET)
+	if err := cfg.DB.TouchRefreshToken(r.Context(), refreshToken); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update refresh token", err)
+		return
+	}
+
+	token, err := auth.MakeJWT(userID, cfg.SECRET, cfg.TokenPolicy, 0)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error: couldn't create access token", err)
 		return
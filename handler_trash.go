@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
+)
+
+// Deleted chirps can be restored by their author for this long, then they
+// are purged
+const chirpTrashRetention = 30 * 24 * time.Hour
+
+// handlerListTrash returns the caller's deleted chirps that can still be
+// restored, the most recently deleted first.
+func (cfg *apiConfig) handlerListTrash(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	chirps, err := cfg.DB.GetTrashedChirps(r.Context(), database.GetTrashedChirpsParams{
+		UserID:       caller.UserID,
+		DeletedAfter: trashCutoff(),
+		LimitCount:   limit,
+		OffsetCount:  offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapChirps(chirps))
+}
+
+// handlerRestoreChirp takes a chirp out of the trash. It is published as
+// created again, so the clients that saw it deleted get it back.
+func (cfg *apiConfig) handlerRestoreChirp(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirpIncludingDeleted(r.Context(), chirpID)
+	if err == sql.ErrNoRows || (err == nil && !chirp.DeletedAt.Valid) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found in the trash", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	if caller.UserID != chirp.UserID {
+		respondWithError(w, http.StatusForbidden, "Forbidden: can't restore chirps from other users!", nil)
+		return
+	}
+
+	var restored database.Chirp
+	err = cfg.DB.InTx(r.Context(), func(q *database.Queries) error {
+		var err error
+		restored, err = q.RestoreChirp(r.Context(), database.RestoreChirpParams{
+			ID:           chirpID,
+			DeletedAfter: trashCutoff(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return publishEvent(r.Context(), q, webhooks.EventChirpCreated, mapChirp(restored))
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusGone, "Chirp was deleted too long ago to be restored", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't restore chirp", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.mapChirpWithPreviews(r.Context(), restored))
+}
+
+// handlerListDeletedChirps lets moderators see deleted chirps, including
+// the ones past the trash retention kept for an unresolved flag.
+func (cfg *apiConfig) handlerListDeletedChirps(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	chirps, err := cfg.DB.ListDeletedChirps(r.Context(), database.ListDeletedChirpsParams{
+		LimitCount:  limit,
+		OffsetCount: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapChirps(chirps))
+}
+
+// handlerModeratorGetChirp returns any chirp, deleted or not, whoever can
+// read it.
+func (cfg *apiConfig) handlerModeratorGetChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID", err)
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirpIncludingDeleted(r.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Chirp not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapChirp(chirp))
+}
+
+// purgeTrashedChirps deletes the chirps that have been in the trash for
+// longer than the retention. Chirps with unresolved flags are kept as
+// evidence until a moderator resolves them.
+func (cfg *apiConfig) purgeTrashedChirps(ctx context.Context) error {
+	purged, err := cfg.DB.PurgeDeletedChirps(ctx, trashCutoff())
+	if err != nil {
+		return err
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d deleted chirps", purged)
+	}
+	return nil
+}
+
+func trashCutoff() sql.NullTime {
+	return sql.NullTime{Time: time.Now().UTC().Add(-chirpTrashRetention), Valid: true}
+}
+
+func mapChirps(chirps []database.Chirp) []Chirp {
+	response := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		response = append(response, mapChirp(chirp))
+	}
+	return response
+}
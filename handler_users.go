@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
@@ -9,9 +12,16 @@ import (
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/billing"
 	"github.com/miguelsoffarelli/chirpy/internal/database"
 )
 
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
 type User struct {
 	ID           uuid.UUID `json:"id"`
 	CreatedAt    time.Time `json:"created_at"`
@@ -20,6 +30,7 @@ type User struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Badge        string    `json:"badge,omitempty"`
 }
 
 func (cfg *apiConfig) handlerUsers(w http.ResponseWriter, r *http.Request) {
@@ -31,19 +42,21 @@ func (cfg *apiConfig) handlerUsers(w http.ResponseWriter, r *http.Request) {
 	params := userParameters{}
 	err := decodeJSON(r, &params)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "The request body isn't valid JSON", err)
 		return
 	}
 
-	// Check if email is valid
-	if !govalidator.IsEmail(params.Email) {
-		respondWithError(w, http.StatusBadRequest, "Email not valid", nil)
+	v := &validator{}
+	checkEmail(v, params.Email)
+	cfg.checkPassword(v, params.Password)
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
 		return
 	}
 
 	hashedPswd, err := auth.HashPassword(params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Password not valid", err)
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't hash password", err)
 		return
 	}
 
@@ -54,14 +67,40 @@ func (cfg *apiConfig) handlerUsers(w http.ResponseWriter, r *http.Request) {
 
 	user, err := cfg.DB.CreateUser(r.Context(), createUserParams)
 	if isUniqueConstraintError(err) { // Check for duplicates
-		respondWithError(w, http.StatusConflict, "Email already in use, try a different one", nil)
+		respondWithProblem(w, http.StatusConflict, problemEmailTaken, "Email already in use, try a different one", nil)
 		return
 	} else if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't create user", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, mapUser(user))
+	respondWithJSON(w, http.StatusCreated, mapUser(user, false))
+}
+
+func checkEmail(v *validator, email string) {
+	if email == "" {
+		v.add("email", fieldRequired, "Email is required")
+	} else if !govalidator.IsEmail(email) {
+		v.add("email", fieldInvalidEmail, "Email not valid")
+	}
+}
+
+// checkPassword checks a new password against the password policy.
+func (cfg *apiConfig) checkPassword(v *validator, password string) {
+	err := cfg.PasswordPolicy.Validate(password)
+	switch {
+	case err == nil:
+	case password == "":
+		v.add("password", fieldRequired, "Password is required")
+	case errors.Is(err, auth.ErrPasswordTooShort):
+		v.add("password", fieldTooShort, err.Error())
+	case errors.Is(err, auth.ErrPasswordTooLong):
+		v.add("password", fieldTooLong, err.Error())
+	case errors.Is(err, auth.ErrBreachedPassword):
+		v.add("password", fieldBreached, err.Error())
+	default:
+		v.add("password", fieldInvalid, err.Error())
+	}
 }
 
 // Check for SQL State 23505 for duplicate unique key
@@ -72,7 +111,16 @@ func isUniqueConstraintError(err error) bool {
 	return false
 }
 
-func mapUser(user database.User, tokens ...string) User {
+func isForeignKeyError(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return pqErr.Code == "23503"
+	}
+	return false
+}
+
+// mapUser builds the user response. Chirpy Red membership comes from the
+// user's subscription, see IsChirpyRed, and decides the profile badge.
+func mapUser(user database.User, isChirpyRed bool, tokens ...string) User {
 	var userToken string
 	var refresh_token string
 	if len(tokens) > 0 {
@@ -86,7 +134,8 @@ func mapUser(user database.User, tokens ...string) User {
 		Email:        user.Email,
 		Token:        userToken,
 		RefreshToken: refresh_token,
-		IsChirpyRed:  user.IsChirpyRed,
+		IsChirpyRed:  isChirpyRed,
+		Badge:        billing.EntitlementsFor(isChirpyRed).ProfileBadge,
 	}
 }
 
@@ -99,52 +148,86 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 
 	params := loginParams{}
 	if err := decodeJSON(r, &params); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "The request body isn't valid JSON", err)
 		return
 	}
 
-	if params.ExpiresInSeconds == 0 {
-		params.ExpiresInSeconds = 3600
+	v := &validator{}
+	v.check(params.Email != "", "email", fieldRequired, "Email is required")
+	v.check(params.Password != "", "password", fieldRequired, "Password is required")
+	v.check(params.ExpiresInSeconds >= 0, "expires_in_seconds", fieldInvalid, "Expiration can't be negative")
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
+		return
 	}
 
-	params.ExpiresInSeconds = min(params.ExpiresInSeconds, 3600)
+	expiresIn := cfg.TokenPolicy.ClampAccessTTL(time.Duration(params.ExpiresInSeconds) * time.Second)
 
-	user, err := cfg.DB.GetUserByEmail(r.Context(), params.Email)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+	user, wait, err := cfg.checkLogin(r.Context(), cfg.loginThrottleKeys(r, params.Email), params.Email, params.Password)
+	if err == errInvalidCredentials {
+		respondWithProblem(w, http.StatusUnauthorized, problemInvalidCredentials, "Incorrect email or password", nil)
+		return
+	} else if err != nil {
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't log in", err)
 		return
 	}
 
-	if err := auth.CheckPasswordHash(params.Password, user.HashedPassword); err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		respondWithProblem(w, http.StatusTooManyRequests, problemTooManyAttempts, "Too many failed login attempts, try again later", nil)
 		return
 	}
 
-	token, err := auth.MakeJWT(user.ID, cfg.SECRET)
+	// Upgrade old bcrypt hashes (or outdated parameters) now that we know the password
+	if auth.NeedsRehash(user.HashedPassword) {
+		if err := cfg.rehashPassword(r.Context(), user.ID, params.Password); err != nil {
+			log.Printf("couldn't rehash password of user %s: %v", user.ID, err)
+		}
+	}
+
+	token, err := auth.MakeJWT(user.ID, cfg.SECRET, cfg.TokenPolicy, expiresIn)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authentication error: failed to create token", err)
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't create access token", err)
 		return
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating refresh token", err)
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't create refresh token", err)
 		return
 	}
 
 	refreshTokenParams := database.CreateRefreshTokenParams{
 		Token:     refreshToken,
 		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * 60),
+		ExpiresAt: cfg.TokenPolicy.RefreshTokenExpiry(),
 		RevokedAt: sql.NullTime{},
 	}
 
 	if _, err := cfg.DB.CreateRefreshToken(r.Context(), refreshTokenParams); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error storing refresh token in database", err)
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't store refresh token", err)
+		return
+	}
+
+	isChirpyRed, err := cfg.DB.IsChirpyRed(r.Context(), user.ID)
+	if err != nil {
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't load subscription", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, mapUser(user, token, refreshToken))
+	respondWithJSON(w, http.StatusOK, mapUser(user, isChirpyRed, token, refreshToken))
+}
+
+func (cfg *apiConfig) rehashPassword(ctx context.Context, userID uuid.UUID, password string) error {
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return cfg.DB.UpdatePasswordHash(ctx, database.UpdatePasswordHashParams{
+		ID:             userID,
+		HashedPassword: hashedPassword,
+	})
 }
 
 func (cfg *apiConfig) handlerCredentials(w http.ResponseWriter, r *http.Request) {
@@ -155,77 +238,93 @@ func (cfg *apiConfig) handlerCredentials(w http.ResponseWriter, r *http.Request)
 
 	params := credentialsParams{}
 	if err := decodeJSON(r, &params); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "The request body isn't valid JSON", err)
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authentication error: couldn't get access token", err)
-		return
-	}
+	caller, _ := principalFromContext(r.Context())
 
-	userID, err := auth.ValidateJWT(token, cfg.SECRET)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Authentication error: invalid or expired access token", err)
+	v := &validator{}
+	checkEmail(v, params.Email)
+	cfg.checkPassword(v, params.Password)
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
 		return
 	}
 
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error: couldn't hash password", err)
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't hash password", err)
 		return
 	}
 
 	updateCredentialsParams := database.UpdateCredentialsParams{
-		ID:             userID,
+		ID:             caller.UserID,
 		Email:          params.Email,
 		HashedPassword: hashedPassword,
 	}
 
 	updatedUser, err := cfg.DB.UpdateCredentials(r.Context(), updateCredentialsParams)
+	if isUniqueConstraintError(err) {
+		respondWithProblem(w, http.StatusConflict, problemEmailTaken, "Email already in use, try a different one", nil)
+		return
+	} else if err != nil {
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't update credentials", err)
+		return
+	}
+
+	isChirpyRed, err := cfg.DB.IsChirpyRed(r.Context(), updatedUser.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Database error: failed to update credentials", err)
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't load subscription", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, mapUser(updatedUser))
+	respondWithJSON(w, http.StatusOK, mapUser(updatedUser, isChirpyRed))
 }
 
-func (cfg *apiConfig) handlerChirpyRed(w http.ResponseWriter, r *http.Request) {
-	type ChirpyRedParams struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID uuid.UUID `json:"user_id"`
-		} `json:"data"`
-	}
+// ContentPreferences tell clients whether to expand chirps with a content
+// warning and show sensitive media without a click.
+type ContentPreferences struct {
+	ExpandContentWarnings bool `json:"expand_content_warnings"`
+	ShowSensitiveMedia    bool `json:"show_sensitive_media"`
+}
 
-	params := ChirpyRedParams{}
-	if err := decodeJSON(r, &params); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Something went wrong", err)
-		return
-	}
+func (cfg *apiConfig) handlerGetContentPreferences(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
 
-	apiKey, err := auth.GetApiKey(r.Header)
+	user, err := cfg.DB.GetUserByID(r.Context(), caller.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Something went wrong", err)
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
 		return
 	}
 
-	if apiKey != cfg.POLKA_KEY {
-		respondWithError(w, http.StatusUnauthorized, "Error: invalid api key", nil)
-		return
-	}
+	respondWithJSON(w, http.StatusOK, ContentPreferences{
+		ExpandContentWarnings: user.ExpandContentWarnings,
+		ShowSensitiveMedia:    user.ShowSensitiveMedia,
+	})
+}
+
+func (cfg *apiConfig) handlerUpdateContentPreferences(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromContext(r.Context())
 
-	if params.Event != "user.upgraded" {
-		respondWithJSON(w, http.StatusNoContent, nil)
+	params := ContentPreferences{}
+	if err := decodeJSON(r, &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
-	if err := cfg.DB.UpgradeUser(r.Context(), params.Data.UserID); err != nil {
-		respondWithError(w, http.StatusNotFound, "Error: user not found", err)
+	user, err := cfg.DB.UpdateContentPreferences(r.Context(), database.UpdateContentPreferencesParams{
+		ID:                    caller.UserID,
+		ExpandContentWarnings: params.ExpandContentWarnings,
+		ShowSensitiveMedia:    params.ShowSensitiveMedia,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update content preferences", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusNoContent, nil)
+	respondWithJSON(w, http.StatusOK, ContentPreferences{
+		ExpandContentWarnings: user.ExpandContentWarnings,
+		ShowSensitiveMedia:    user.ShowSensitiveMedia,
+	})
 }
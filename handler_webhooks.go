@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/billing"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+// Polka webhooks are small, anything bigger isn't a legitimate event
+const maxWebhookBodySize = 64 << 10
+
+const (
+	webhookOutcomePending   = "pending"
+	webhookOutcomeProcessed = "processed"
+	webhookOutcomeIgnored   = "ignored"
+	webhookOutcomeFailed    = "failed"
+)
+
+var errWebhookUserNotFound = errors.New("user not found")
+
+type polkaEvent struct {
+	ID    string          `json:"id"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// polkaEventHandler applies an event. Event types without a handler are
+// recorded as ignored.
+type polkaEventHandler func(ctx context.Context, event database.WebhookEvent, data json.RawMessage) error
+
+func (cfg *apiConfig) polkaEventHandlers() map[string]polkaEventHandler {
+	return map[string]polkaEventHandler{
+		billing.EventUserUpgraded:        cfg.handlePolkaSubscriptionEvent,
+		billing.EventUserDowngraded:      cfg.handlePolkaSubscriptionEvent,
+		billing.EventSubscriptionRenewed: cfg.handlePolkaSubscriptionEvent,
+		billing.EventPaymentFailed:       cfg.handlePolkaSubscriptionEvent,
+		billing.EventPaymentRefunded:     cfg.handlePolkaSubscriptionEvent,
+	}
+}
+
+type WebhookEvent struct {
+	ID          string          `json:"id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+	Outcome     string          `json:"outcome"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int32           `json:"attempts"`
+}
+
+func (cfg *apiConfig) handlerChirpyRed(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "Couldn't read request body", err)
+		return
+	}
+
+	// Verify the signature before looking at the payload at all
+	if err := cfg.PolkaVerifier.Verify(r.Header, body); err != nil {
+		respondWithProblem(w, http.StatusUnauthorized, problemInvalidSignature, "Invalid webhook signature", err)
+		return
+	}
+
+	params := polkaEvent{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		respondWithProblem(w, http.StatusBadRequest, problemInvalidBody, "The request body isn't valid JSON", err)
+		return
+	}
+
+	v := &validator{}
+	v.check(params.Event != "", "event", fieldRequired, "Event is required")
+	if !v.valid() {
+		respondWithValidationProblem(w, v)
+		return
+	}
+
+	eventID := webhookEventID(r, params)
+	event, err := cfg.DB.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		ID:        eventID,
+		EventType: params.Event,
+		Payload:   body,
+	})
+	if err == sql.ErrNoRows {
+		// Already received, only failed or interrupted events are
+		// processed again
+		event, err = cfg.DB.ClaimWebhookEvent(r.Context(), eventID)
+		if err == sql.ErrNoRows {
+			respondWithJSON(w, http.StatusNoContent, nil)
+			return
+		} else if err != nil {
+			respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't load webhook event", err)
+			return
+		}
+	} else if err != nil {
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't store webhook event", err)
+		return
+	}
+
+	if _, err := cfg.processWebhookEvent(r.Context(), event); err != nil {
+		if errors.Is(err, errWebhookUserNotFound) {
+			respondWithProblem(w, http.StatusNotFound, problemUserNotFound, "User not found", err)
+			return
+		}
+		respondWithProblem(w, http.StatusInternalServerError, problemInternal, "Couldn't process webhook event", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// webhookEventID returns the ID used to deduplicate the event. Events
+// without an ID from Polka are identified by their signature headers: a
+// replay of a captured request has the same ones and collapses onto the
+// stored event, while a legitimate event with the same content (e.g. the
+// next renewal) is signed at another time.
+func webhookEventID(r *http.Request, event polkaEvent) string {
+	if event.ID != "" {
+		return event.ID
+	}
+	if id := r.Header.Get("X-Polka-Event-Id"); id != "" {
+		return id
+	}
+
+	sum := sha256.Sum256([]byte(r.Header.Get(auth.WebhookTimestampHeader) + "\n" + r.Header.Get(auth.WebhookSignatureHeader)))
+	return "signed:" + hex.EncodeToString(sum[:])
+}
+
+// processWebhookEvent applies a stored event and records the outcome.
+func (cfg *apiConfig) processWebhookEvent(ctx context.Context, event database.WebhookEvent) (database.WebhookEvent, error) {
+	params := polkaEvent{}
+	if err := json.Unmarshal(event.Payload, &params); err != nil {
+		return cfg.finishWebhookEvent(ctx, event.ID, err)
+	}
+
+	handler, ok := cfg.polkaEventHandlers()[event.EventType]
+	if !ok {
+		finished, err := cfg.DB.FinishWebhookEvent(ctx, database.FinishWebhookEventParams{
+			ID:      event.ID,
+			Outcome: webhookOutcomeIgnored,
+		})
+		return finished, err
+	}
+
+	return cfg.finishWebhookEvent(ctx, event.ID, handler(ctx, event, params.Data))
+}
+
+// finishWebhookEvent stores the result of processing an event and returns
+// the processing error, if any.
+func (cfg *apiConfig) finishWebhookEvent(ctx context.Context, eventID string, processErr error) (database.WebhookEvent, error) {
+	finish := database.FinishWebhookEventParams{
+		ID:      eventID,
+		Outcome: webhookOutcomeProcessed,
+	}
+	if processErr != nil {
+		finish.Outcome = webhookOutcomeFailed
+		finish.Error = sql.NullString{String: processErr.Error(), Valid: true}
+	}
+
+	finished, err := cfg.DB.FinishWebhookEvent(ctx, finish)
+	if err != nil {
+		return finished, err
+	}
+
+	return finished, processErr
+}
+
+func (cfg *apiConfig) handlerListWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	outcome := r.URL.Query().Get("outcome")
+	switch outcome {
+	case "", webhookOutcomePending, webhookOutcomeProcessed, webhookOutcomeIgnored, webhookOutcomeFailed:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid outcome", nil)
+		return
+	}
+
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	events, err := cfg.DB.ListWebhookEvents(r.Context(), database.ListWebhookEventsParams{
+		Outcome:     outcome,
+		LimitCount:  limit,
+		OffsetCount: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	response := make([]WebhookEvent, 0, len(events))
+	for _, event := range events {
+		response = append(response, mapWebhookEvent(event))
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerReplayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	eventID := r.PathValue("eventID")
+	if _, err := cfg.DB.GetWebhookEvent(r.Context(), eventID); err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Webhook event not found", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	// Claiming it keeps two replays from applying it twice
+	event, err := cfg.DB.ClaimWebhookEvent(r.Context(), eventID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusConflict, "Only failed events and pending ones that were interrupted can be replayed", nil)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong", err)
+		return
+	}
+
+	// A failed replay is still a successful request, the new outcome is in the response
+	event, err = cfg.processWebhookEvent(r.Context(), event)
+	if err != nil && event.Outcome != webhookOutcomeFailed {
+		respondWithError(w, http.StatusInternalServerError, "Database error: couldn't update webhook event", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, mapWebhookEvent(event))
+}
+
+func mapWebhookEvent(event database.WebhookEvent) WebhookEvent {
+	return WebhookEvent{
+		ID:          event.ID,
+		EventType:   event.EventType,
+		Payload:     event.Payload,
+		ReceivedAt:  event.ReceivedAt,
+		ProcessedAt: nullTimePtr(event.ProcessedAt),
+		Outcome:     event.Outcome,
+		Error:       event.Error.String,
+		Attempts:    event.Attempts,
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/stream"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
+)
+
+const (
+	wsWriteTimeout   = 10 * time.Second
+	wsPongTimeout    = 60 * time.Second
+	wsPingInterval   = 30 * time.Second
+	wsMaxMessageSize = 4 << 10
+	wsMaxChannels    = 50
+	// Replies to the client's own messages, events are queued in the hub
+	wsSendBufferSize = 16
+	// Typing indicators sent more often than this are dropped
+	wsTypingInterval = 2 * time.Second
+)
+
+// Typing indicators aren't stored, they go through their own channel
+const (
+	typingChannel = "typing_events"
+	eventTyping   = "typing"
+)
+
+// Channel names: timeline:<user ID>, hashtag:<tag>, thread:<chirp ID> and
+// notifications for the caller's own notifications.
+const (
+	wsChannelTimeline      = "timeline"
+	wsChannelHashtag       = "hashtag"
+	wsChannelThread        = "thread"
+	wsChannelNotifications = "notifications"
+)
+
+var (
+	hashtagPattern     = regexp.MustCompile(`#([\p{L}\p{N}_]{1,64})`)
+	hashtagNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_]{1,64}$`)
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type wsClientMessage struct {
+	Type    string    `json:"type"`
+	Channel string    `json:"channel"`
+	To      uuid.UUID `json:"to"`
+}
+
+type wsServerMessage struct {
+	Type    string          `json:"type"`
+	Channel string          `json:"channel,omitempty"`
+	Event   string          `json:"event,omitempty"`
+	ID      int64           `json:"id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type typingIndicator struct {
+	From uuid.UUID `json:"from"`
+	To   uuid.UUID `json:"to"`
+}
+
+type wsClient struct {
+	cfg    *apiConfig
+	caller principal
+	conn   *websocket.Conn
+	send   chan wsServerMessage
+	// closed is closed once the connection stops being written to
+	closed chan struct{}
+
+	mu         sync.Mutex
+	channels   map[string]bool
+	lastTyping time.Time
+}
+
+// handlerWebSocket upgrades the connection and lets the client subscribe to
+// real-time channels. Browsers can't set headers on WebSocket requests, so
+// the token can also be sent in the access_token query parameter.
+func (cfg *apiConfig) handlerWebSocket(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		token = r.URL.Query().Get("access_token")
+	}
+	if token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Authentication error: couldn't get access token", err)
+		return
+	}
+
+	caller, err := cfg.authenticate(r.Context(), token)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Authentication error: invalid or expired token", err)
+		return
+	}
+	if !caller.HasScope(auth.ScopeChirpsRead) {
+		respondWithError(w, http.StatusForbidden, "Forbidden: token is missing the "+string(auth.ScopeChirpsRead)+" scope", nil)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already responded
+		return
+	}
+
+	cfg.websocketConnections.Add(1)
+	defer cfg.websocketConnections.Add(-1)
+
+	client := &wsClient{
+		cfg:      cfg,
+		caller:   caller,
+		conn:     conn,
+		send:     make(chan wsServerMessage, wsSendBufferSize),
+		closed:   make(chan struct{}),
+		channels: make(map[string]bool),
+	}
+	sub := cfg.Stream.Subscribe(client.wants, streamBufferSize)
+
+	done := make(chan struct{})
+	go func() {
+		client.writePump(sub, done)
+		close(client.closed)
+		conn.Close()
+	}()
+
+	client.readPump()
+	close(done)
+	sub.Close()
+}
+
+func (c *wsClient) readPump() {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		msg := wsClientMessage{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.reply(wsServerMessage{Type: "error", Error: "invalid message"})
+			continue
+		}
+
+		c.handleMessage(msg)
+	}
+}
+
+// writePump is the only writer of the connection. A client that doesn't
+// read its events fast enough is dropped from the hub, it is then
+// disconnected and should reconnect and resubscribe.
+func (c *wsClient) writePump(sub *stream.Subscription, done <-chan struct{}) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			c.writeClose(websocket.CloseNormalClosure, "")
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				c.writeClose(websocket.CloseTryAgainLater, "client too slow")
+				return
+			}
+			messages := c.messagesFor(event)
+			if len(messages) > 0 && !c.visible(event) {
+				continue
+			}
+			for _, msg := range messages {
+				if err := c.write(msg); err != nil {
+					return
+				}
+			}
+		case msg := <-c.send:
+			if err := c.write(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// visible reports whether the caller can read the chirp of event.
+func (c *wsClient) visible(event stream.Event) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+	defer cancel()
+
+	return c.cfg.streamEventVisible(ctx, c.caller.UserID, event)
+}
+
+func (c *wsClient) write(msg wsServerMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *wsClient) writeClose(code int, text string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(wsWriteTimeout))
+}
+
+// reply blocks while the send buffer is full, so a client that floods us
+// with messages is slowed down instead of queuing replies without bound.
+func (c *wsClient) reply(msg wsServerMessage) {
+	select {
+	case c.send <- msg:
+	case <-c.closed:
+	}
+}
+
+func (c *wsClient) handleMessage(msg wsClientMessage) {
+	switch msg.Type {
+	case "subscribe":
+		if err := c.checkChannel(msg.Channel); err != "" {
+			c.reply(wsServerMessage{Type: "error", Channel: msg.Channel, Error: err})
+			return
+		}
+
+		c.mu.Lock()
+		full := len(c.channels) >= wsMaxChannels && !c.channels[msg.Channel]
+		if !full {
+			c.channels[msg.Channel] = true
+		}
+		c.mu.Unlock()
+
+		if full {
+			c.reply(wsServerMessage{Type: "error", Channel: msg.Channel, Error: "too many subscriptions"})
+			return
+		}
+		c.reply(wsServerMessage{Type: "subscribed", Channel: msg.Channel})
+	case "unsubscribe":
+		c.mu.Lock()
+		delete(c.channels, msg.Channel)
+		c.mu.Unlock()
+
+		c.reply(wsServerMessage{Type: "unsubscribed", Channel: msg.Channel})
+	case "typing":
+		c.sendTyping(msg.To)
+	case "ping":
+		c.reply(wsServerMessage{Type: "pong"})
+	default:
+		c.reply(wsServerMessage{Type: "error", Error: "unknown message type"})
+	}
+}
+
+// checkChannel returns why the client can't subscribe to channel, if it
+// can't.
+func (c *wsClient) checkChannel(channel string) string {
+	kind, arg, _ := strings.Cut(channel, ":")
+	switch kind {
+	case wsChannelTimeline, wsChannelThread:
+		if _, err := uuid.Parse(arg); err != nil {
+			return "invalid channel ID"
+		}
+	case wsChannelHashtag:
+		if !hashtagNamePattern.MatchString(arg) || arg != strings.ToLower(arg) {
+			return "invalid hashtag, use lowercase letters, numbers and _"
+		}
+	case wsChannelNotifications:
+		if arg != "" {
+			return "unknown channel"
+		}
+		if !c.caller.HasScope(auth.ScopeProfileRead) {
+			return "token is missing the " + string(auth.ScopeProfileRead) + " scope"
+		}
+	default:
+		return "unknown channel"
+	}
+
+	return ""
+}
+
+func (c *wsClient) sendTyping(to uuid.UUID) {
+	if !c.caller.HasScope(auth.ScopeMessagesWrite) {
+		c.reply(wsServerMessage{Type: "error", Error: "token is missing the " + string(auth.ScopeMessagesWrite) + " scope"})
+		return
+	}
+	if to == uuid.Nil || to == c.caller.UserID {
+		c.reply(wsServerMessage{Type: "error", Error: "invalid typing recipient"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+	defer cancel()
+
+	// Typing indicators are for direct messages, which blocked users can't
+	// send each other
+	shared, err := c.cfg.DB.SharesConversation(ctx, database.SharesConversationParams{
+		UserID:      c.caller.UserID,
+		OtherUserID: to,
+	})
+	if err != nil || !shared {
+		c.reply(wsServerMessage{Type: "error", Error: "invalid typing recipient"})
+		return
+	}
+
+	blocked, err := c.cfg.DB.IsBlockedEitherWay(ctx, database.IsBlockedEitherWayParams{
+		BlockerID: c.caller.UserID,
+		BlockedID: to,
+	})
+	if err != nil || blocked {
+		c.reply(wsServerMessage{Type: "error", Error: "invalid typing recipient"})
+		return
+	}
+
+	c.mu.Lock()
+	throttled := time.Since(c.lastTyping) < wsTypingInterval
+	if !throttled {
+		c.lastTyping = time.Now()
+	}
+	c.mu.Unlock()
+	if throttled {
+		return
+	}
+
+	payload, err := json.Marshal(typingIndicator{From: c.caller.UserID, To: to})
+	if err != nil {
+		return
+	}
+
+	// Sent through the database so clients connected to other instances get it
+	if err := c.cfg.DB.NotifyTyping(ctx, string(payload)); err != nil {
+		log.Printf("Couldn't send typing indicator: %v", err)
+	}
+}
+
+// wants is the hub filter, it is cheap and messagesFor does the matching.
+func (c *wsClient) wants(event stream.Event) bool {
+	if event.Type == eventTyping {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels) > 0
+}
+
+// messagesFor returns a message for every channel of the client the event
+// belongs to.
+func (c *wsClient) messagesFor(event stream.Event) []wsServerMessage {
+	switch event.Type {
+	case eventTyping:
+		typing := typingIndicator{}
+		if err := json.Unmarshal(event.Payload, &typing); err != nil || typing.To != c.caller.UserID {
+			return nil
+		}
+		if !c.caller.HasScope(auth.ScopeMessagesRead) {
+			return nil
+		}
+		return []wsServerMessage{{Type: eventTyping, Data: event.Payload}}
+	case eventNotificationCreated:
+		notification := struct {
+			UserID uuid.UUID `json:"user_id"`
+		}{}
+		if err := json.Unmarshal(event.Payload, &notification); err != nil || notification.UserID != c.caller.UserID {
+			return nil
+		}
+		return c.eventMessages(event, []string{wsChannelNotifications})
+	case webhooks.EventChirpCreated, eventChirpUpdated, webhooks.EventChirpDeleted:
+		chirp := Chirp{}
+		if err := json.Unmarshal(event.Payload, &chirp); err != nil {
+			return nil
+		}
+
+		channels := []string{
+			wsChannelTimeline + ":" + chirp.UserID.String(),
+			wsChannelThread + ":" + chirp.ID.String(),
+		}
+		for _, match := range hashtagPattern.FindAllStringSubmatch(chirp.Body, -1) {
+			channels = append(channels, wsChannelHashtag+":"+strings.ToLower(match[1]))
+		}
+		return c.eventMessages(event, channels)
+	}
+
+	return nil
+}
+
+func (c *wsClient) eventMessages(event stream.Event, channels []string) []wsServerMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var messages []wsServerMessage
+	for _, channel := range channels {
+		if c.channels[channel] {
+			messages = append(messages, wsServerMessage{
+				Type:    "event",
+				Channel: channel,
+				Event:   event.Type,
+				ID:      event.Seq,
+				Data:    event.Payload,
+			})
+		}
+	}
+
+	return messages
+}
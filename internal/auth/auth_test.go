@@ -1,23 +1,31 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestValidateJWT(t *testing.T) {
 	userID := uuid.New()
+	policy := DefaultTokenPolicy()
 
 	t.Run("basic use case", func(t *testing.T) {
-		token, err := MakeJWT(userID, "kerfuffle")
+		token, err := MakeJWT(userID, "kerfuffle", policy, 0)
 		if err != nil {
 			t.Fatalf("error creating token: %v", err)
 		}
 
-		id, err := ValidateJWT(token, "kerfuffle")
+		id, err := ValidateJWT(token, "kerfuffle", policy)
 		if err != nil {
 			t.Fatalf("error validating token: %v", err)
 		}
@@ -28,24 +36,95 @@ func TestValidateJWT(t *testing.T) {
 	})
 
 	t.Run("expired token", func(t *testing.T) {
-		token, err := MakeJWT(userID, "kerfuffle")
+		issuedInThePast := policy
+		issuedInThePast.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+
+		token, err := MakeJWT(userID, "kerfuffle", issuedInThePast, time.Hour)
 		if err != nil {
 			t.Fatalf("error creating token: %v", err)
 		}
 
-		_, err = ValidateJWT(token, "kerfuffle")
+		_, err = ValidateJWT(token, "kerfuffle", policy)
 		if err == nil {
 			t.Fatalf("no errors returned despite expired token")
 		}
 	})
 
+	t.Run("token valid until its requested lifetime", func(t *testing.T) {
+		issuedRecently := policy
+		issuedRecently.now = func() time.Time { return time.Now().Add(-30 * time.Minute) }
+
+		token, err := MakeJWT(userID, "kerfuffle", issuedRecently, 45*time.Minute)
+		if err != nil {
+			t.Fatalf("error creating token: %v", err)
+		}
+
+		if _, err := ValidateJWT(token, "kerfuffle", policy); err != nil {
+			t.Fatalf("expected token to still be valid, got: %v", err)
+		}
+	})
+
+	t.Run("requested lifetime is clamped", func(t *testing.T) {
+		issuedInThePast := policy
+		issuedInThePast.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+
+		token, err := MakeJWT(userID, "kerfuffle", issuedInThePast, 24*time.Hour)
+		if err != nil {
+			t.Fatalf("error creating token: %v", err)
+		}
+
+		_, err = ValidateJWT(token, "kerfuffle", policy)
+		if err == nil {
+			t.Fatalf("expected token to expire after the max lifetime")
+		}
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		other := policy
+		other.Issuer = "not-chirpy"
+
+		token, err := MakeJWT(userID, "kerfuffle", other, 0)
+		if err != nil {
+			t.Fatalf("error creating token: %v", err)
+		}
+
+		_, err = ValidateJWT(token, "kerfuffle", policy)
+		if err == nil {
+			t.Fatalf("expected error for a token from another issuer")
+		}
+	})
+
+	t.Run("audience is required when configured", func(t *testing.T) {
+		withAudience := policy
+		withAudience.Audience = "chirpy-api"
+
+		token, err := MakeJWT(userID, "kerfuffle", policy, 0)
+		if err != nil {
+			t.Fatalf("error creating token: %v", err)
+		}
+
+		_, err = ValidateJWT(token, "kerfuffle", withAudience)
+		if err == nil {
+			t.Fatalf("expected error for a token without audience")
+		}
+
+		token, err = MakeJWT(userID, "kerfuffle", withAudience, 0)
+		if err != nil {
+			t.Fatalf("error creating token: %v", err)
+		}
+
+		if _, err := ValidateJWT(token, "kerfuffle", withAudience); err != nil {
+			t.Fatalf("expected valid token, got: %v", err)
+		}
+	})
+
 	t.Run("create and validate with empty secret", func(t *testing.T) {
-		token, err := MakeJWT(userID, "")
+		token, err := MakeJWT(userID, "", policy, 0)
 		if err != nil {
 			t.Fatalf("unexpected error creating token: %v", err)
 		}
 
-		id, err := ValidateJWT(token, "")
+		id, err := ValidateJWT(token, "", policy)
 		if err != nil {
 			t.Fatalf("expected valid JWT with empty secret, got error: %v", err)
 		}
@@ -56,18 +135,88 @@ func TestValidateJWT(t *testing.T) {
 	})
 
 	t.Run("create with secret, validate with empty secret", func(t *testing.T) {
-		token, err := MakeJWT(userID, "kerfuffle")
+		token, err := MakeJWT(userID, "kerfuffle", policy, 0)
 		if err != nil {
 			t.Fatalf("unexpected error creating token: %v", err)
 		}
 
-		_, err = ValidateJWT(token, "")
+		_, err = ValidateJWT(token, "", policy)
 		if err == nil {
 			t.Fatalf("expected error when validating with wrong (empty) secret, got none")
 		}
 	})
 }
 
+func TestTokenPolicy(t *testing.T) {
+	policy := DefaultTokenPolicy()
+
+	t.Run("clamp access token lifetime", func(t *testing.T) {
+		cases := []struct {
+			requested time.Duration
+			expected  time.Duration
+		}{
+			{0, policy.AccessTokenTTL},
+			{-time.Minute, policy.AccessTokenTTL},
+			{10 * time.Minute, 10 * time.Minute},
+			{48 * time.Hour, policy.MaxAccessTokenTTL},
+		}
+
+		for _, c := range cases {
+			if got := policy.ClampAccessTTL(c.requested); got != c.expected {
+				t.Fatalf("requested %v: expected %v, got %v", c.requested, c.expected, got)
+			}
+		}
+	})
+
+	t.Run("refresh token expiry", func(t *testing.T) {
+		now := time.Now()
+		fixed := policy
+		fixed.now = func() time.Time { return now }
+
+		if got := fixed.RefreshTokenExpiry(); !got.Equal(now.Add(60 * 24 * time.Hour)) {
+			t.Fatalf("expected refresh token to expire in 60 days, got %v", got)
+		}
+	})
+
+	t.Run("idle cutoff disabled by default", func(t *testing.T) {
+		if got := policy.RefreshIdleCutoff(); !got.IsZero() {
+			t.Fatalf("expected zero cutoff, got %v", got)
+		}
+	})
+
+	t.Run("load from environment", func(t *testing.T) {
+		env := map[string]string{
+			"ACCESS_TOKEN_TTL":           "15m",
+			"ACCESS_TOKEN_MAX_TTL":       "2h",
+			"REFRESH_TOKEN_IDLE_TIMEOUT": "168h",
+			"JWT_AUDIENCE":               "chirpy-api",
+		}
+
+		loaded, err := LoadTokenPolicy(func(key string) string { return env[key] })
+		if err != nil {
+			t.Fatalf("unexpected error loading policy: %v", err)
+		}
+
+		if loaded.AccessTokenTTL != 15*time.Minute || loaded.MaxAccessTokenTTL != 2*time.Hour {
+			t.Fatalf("unexpected access token lifetimes: %v, %v", loaded.AccessTokenTTL, loaded.MaxAccessTokenTTL)
+		}
+		if loaded.RefreshIdleTimeout != 168*time.Hour || loaded.Audience != "chirpy-api" || loaded.Issuer != "chirpy" {
+			t.Fatalf("unexpected policy loaded: %+v", loaded)
+		}
+	})
+
+	t.Run("reject default above max", func(t *testing.T) {
+		env := map[string]string{
+			"ACCESS_TOKEN_TTL":     "2h",
+			"ACCESS_TOKEN_MAX_TTL": "1h",
+		}
+
+		if _, err := LoadTokenPolicy(func(key string) string { return env[key] }); err == nil {
+			t.Fatalf("expected error when the default lifetime exceeds the max")
+		}
+	})
+}
+
 func TestGetBearerToken(t *testing.T) {
 	headers := make(http.Header)
 
@@ -110,3 +259,327 @@ func TestGetBearerToken(t *testing.T) {
 		}
 	})
 }
+
+func TestParseScopes(t *testing.T) {
+	t.Run("valid scopes are deduplicated", func(t *testing.T) {
+		scopes, err := ParseScopes([]string{"chirps:write", "chirps:read", "chirps:write"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(scopes) != 2 || scopes[0] != ScopeChirpsWrite || scopes[1] != ScopeChirpsRead {
+			t.Fatalf("unexpected scopes: %v", scopes)
+		}
+	})
+
+	t.Run("unknown scope", func(t *testing.T) {
+		if _, err := ParseScopes([]string{"chirps:delete-everything"}); err == nil {
+			t.Fatalf("expected error for unknown scope")
+		}
+	})
+
+	t.Run("token management can't be delegated", func(t *testing.T) {
+		if _, err := ParseScopes([]string{string(ScopeTokensManage)}); err == nil {
+			t.Fatalf("expected error when granting %s", ScopeTokensManage)
+		}
+	})
+
+	t.Run("no scopes", func(t *testing.T) {
+		if _, err := ParseScopes(nil); err == nil {
+			t.Fatalf("expected error for empty scope list")
+		}
+	})
+}
+
+func TestMakePersonalAccessToken(t *testing.T) {
+	token, hash, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+
+	if !IsPersonalAccessToken(token) {
+		t.Fatalf("expected %s to be recognized as a personal access token", token)
+	}
+
+	if hash != HashToken(token) {
+		t.Fatalf("hash returned on creation doesn't match the token")
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// Example from RFC 7636, appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	t.Run("valid challenge", func(t *testing.T) {
+		if err := ValidateCodeChallenge(challenge, "S256"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("plain method is rejected", func(t *testing.T) {
+		if err := ValidateCodeChallenge(verifier, "plain"); err == nil {
+			t.Fatalf("expected error for plain code challenge method")
+		}
+	})
+
+	t.Run("matching verifier", func(t *testing.T) {
+		if !VerifyCodeVerifier(verifier, challenge) {
+			t.Fatalf("expected verifier to match the challenge")
+		}
+	})
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		if VerifyCodeVerifier(verifier[:len(verifier)-1]+"a", challenge) {
+			t.Fatalf("expected wrong verifier to be rejected")
+		}
+	})
+}
+
+func TestDelegatedJWT(t *testing.T) {
+	policy := DefaultTokenPolicy()
+	userID := uuid.New()
+	tokenID := uuid.New()
+	clientID := uuid.New()
+
+	token, err := MakeDelegatedJWT(userID, "kerfuffle", policy, tokenID, clientID, []Scope{ScopeChirpsRead, ScopeChirpsWrite}, time.Hour)
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+
+	// Delegated tokens still pass the regular validation
+	id, err := ValidateJWT(token, "kerfuffle", policy)
+	if err != nil || id != userID {
+		t.Fatalf("expected user id %v, got %v (err: %v)", userID, id, err)
+	}
+
+	claims, err := ParseJWT(token, "kerfuffle", policy)
+	if err != nil {
+		t.Fatalf("error parsing token: %v", err)
+	}
+
+	if !claims.Delegated() || claims.ClientID != clientID.String() || claims.ID != tokenID.String() {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	if claims.Scope != "chirps:read chirps:write" {
+		t.Fatalf("unexpected scope claim: %q", claims.Scope)
+	}
+}
+
+func TestThrottlePolicy(t *testing.T) {
+	policy := DefaultAccountThrottlePolicy()
+	last := time.Now()
+
+	cases := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{9, policy.MaxDelay},
+		{10, policy.LockoutDuration},
+		{25, policy.LockoutDuration},
+	}
+
+	for _, c := range cases {
+		if got := policy.RetryAt(c.failures, last).Sub(last); got != c.expected {
+			t.Fatalf("%d failures: expected to wait %v, got %v", c.failures, c.expected, got)
+		}
+	}
+}
+
+func TestPasswordHashing(t *testing.T) {
+	t.Run("argon2id round trip", func(t *testing.T) {
+		hash, err := HashPassword("correct horse battery staple")
+		if err != nil {
+			t.Fatalf("error hashing password: %v", err)
+		}
+
+		if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+			t.Fatalf("unexpected hash format: %s", hash)
+		}
+
+		if err := CheckPasswordHash("correct horse battery staple", hash); err != nil {
+			t.Fatalf("expected password to match, got: %v", err)
+		}
+
+		if err := CheckPasswordHash("correct horse battery stapler", hash); err == nil {
+			t.Fatalf("expected wrong password to be rejected")
+		}
+
+		if NeedsRehash(hash) {
+			t.Fatalf("fresh hash shouldn't need a rehash")
+		}
+	})
+
+	t.Run("long passwords aren't truncated", func(t *testing.T) {
+		long := strings.Repeat("a", 80)
+		hash, err := HashPassword(long + "1")
+		if err != nil {
+			t.Fatalf("error hashing password: %v", err)
+		}
+
+		if err := CheckPasswordHash(long+"2", hash); err == nil {
+			t.Fatalf("expected passwords differing after 72 bytes to be rejected")
+		}
+	})
+
+	t.Run("legacy bcrypt hashes", func(t *testing.T) {
+		hash, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("error creating bcrypt hash: %v", err)
+		}
+
+		if err := CheckPasswordHash("hunter22", string(hash)); err != nil {
+			t.Fatalf("expected bcrypt hash to still verify, got: %v", err)
+		}
+
+		if !NeedsRehash(string(hash)) {
+			t.Fatalf("bcrypt hash should need a rehash")
+		}
+	})
+
+	t.Run("outdated parameters", func(t *testing.T) {
+		weaker := DefaultArgon2Params
+		weaker.Iterations = 1
+		hash, err := hashArgon2id("hunter22", weaker)
+		if err != nil {
+			t.Fatalf("error hashing password: %v", err)
+		}
+
+		if err := CheckPasswordHash("hunter22", hash); err != nil {
+			t.Fatalf("expected password to match, got: %v", err)
+		}
+
+		if !NeedsRehash(hash) {
+			t.Fatalf("hash with outdated parameters should need a rehash")
+		}
+	})
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+
+	listPath := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(listPath, []byte("password123\nqwertyuiop\n"), 0o600); err != nil {
+		t.Fatalf("error writing breached passwords list: %v", err)
+	}
+	if err := policy.LoadBreachedPasswords(listPath); err != nil {
+		t.Fatalf("error loading breached passwords list: %v", err)
+	}
+
+	cases := []struct {
+		password string
+		valid    bool
+	}{
+		{"", false},
+		{"short", false},
+		{"password123", false},
+		{"qwertyuiop", false},
+		{"ñandú-ñandú", true},
+		{"🐦🐦🐦🐦🐦🐦🐦🐦", true},
+		{strings.Repeat("a", 257), false},
+		{"a perfectly fine passphrase", true},
+	}
+
+	for _, c := range cases {
+		err := policy.Validate(c.password)
+		if c.valid && err != nil {
+			t.Fatalf("expected %q to be valid, got: %v", c.password, err)
+		}
+		if !c.valid && err == nil {
+			t.Fatalf("expected %q to be rejected", c.password)
+		}
+	}
+}
+
+func TestWebhookVerifier(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	now := time.Now()
+	verifier := NewWebhookVerifier([]string{"old-secret", "new-secret"}, 5*time.Minute)
+
+	signedHeaders := func(secret string, timestamp time.Time) http.Header {
+		headers := make(http.Header)
+		headers.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+		headers.Set(WebhookSignatureHeader, SignWebhook(secret, timestamp, body))
+		return headers
+	}
+
+	t.Run("valid signature", func(t *testing.T) {
+		if err := verifier.Verify(signedHeaders("new-secret", now), body); err != nil {
+			t.Fatalf("expected valid signature, got: %v", err)
+		}
+	})
+
+	t.Run("any active secret is accepted", func(t *testing.T) {
+		if err := verifier.Verify(signedHeaders("old-secret", now), body); err != nil {
+			t.Fatalf("expected signature with the old secret to be valid, got: %v", err)
+		}
+	})
+
+	t.Run("several signatures in the header", func(t *testing.T) {
+		headers := signedHeaders("new-secret", now)
+		headers.Set(WebhookSignatureHeader, SignWebhook("retired-secret", now, body)+", "+headers.Get(WebhookSignatureHeader))
+		if err := verifier.Verify(headers, body); err != nil {
+			t.Fatalf("expected one matching signature to be enough, got: %v", err)
+		}
+	})
+
+	t.Run("unknown secret", func(t *testing.T) {
+		if err := verifier.Verify(signedHeaders("kerfuffle", now), body); err != ErrInvalidSignature {
+			t.Fatalf("expected ErrInvalidSignature, got: %v", err)
+		}
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		tampered := []byte(strings.Replace(string(body), "upgraded", "downgraded", 1))
+		if err := verifier.Verify(signedHeaders("new-secret", now), tampered); err != ErrInvalidSignature {
+			t.Fatalf("expected ErrInvalidSignature, got: %v", err)
+		}
+	})
+
+	t.Run("replayed after the tolerance window", func(t *testing.T) {
+		if err := verifier.Verify(signedHeaders("new-secret", now.Add(-10*time.Minute)), body); err != ErrStaleTimestamp {
+			t.Fatalf("expected ErrStaleTimestamp, got: %v", err)
+		}
+	})
+
+	t.Run("missing headers", func(t *testing.T) {
+		if err := verifier.Verify(make(http.Header), body); err != ErrMissingSignature {
+			t.Fatalf("expected ErrMissingSignature, got: %v", err)
+		}
+	})
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 16}
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte("password123\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.LoadBreachedPasswords(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{"correct horse", nil},
+		{"short", ErrPasswordTooShort},
+		{"ünïcödé", ErrPasswordTooShort},
+		{"a password that is far too long", ErrPasswordTooLong},
+		{"password123", ErrBreachedPassword},
+	}
+
+	for _, tc := range tests {
+		err := policy.Validate(tc.password)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.password, tc.want, err)
+		}
+	}
+}
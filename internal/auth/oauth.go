@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ParseScopeString parses a space-delimited OAuth scope parameter.
+func ParseScopeString(scope string) ([]Scope, error) {
+	return ParseScopes(strings.Fields(scope))
+}
+
+func FormatScopes(scopes []Scope) string {
+	return strings.Join(ScopeStrings(scopes), " ")
+}
+
+// ScopesSubset reports whether every requested scope is in allowed.
+func ScopesSubset(requested, allowed []Scope) bool {
+	for _, scope := range requested {
+		if !slices.Contains(allowed, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidateCodeChallenge checks the PKCE parameters sent to the authorization
+// endpoint. Only the S256 method is supported.
+func ValidateCodeChallenge(challenge, method string) error {
+	if method != "S256" {
+		return fmt.Errorf("code_challenge_method must be S256")
+	}
+
+	// base64url encoded SHA-256 digest without padding
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("invalid code_challenge")
+	}
+
+	return nil
+}
+
+// VerifyCodeVerifier checks a PKCE code_verifier against the challenge
+// stored with the authorization code (RFC 7636, section 4.6).
+func VerifyCodeVerifier(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// CheckClientSecret compares a client secret with the stored hash in
+// constant time.
+func CheckClientSecret(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(hash)) == 1
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrBreachedPassword = errors.New("password appears in a list of breached passwords, choose a different one")
+)
+
+// PasswordPolicy is checked when a password is set, not when logging in,
+// so users with older passwords can still log in and change them.
+type PasswordPolicy struct {
+	MinLength int // in characters
+	MaxLength int // in characters
+	breached  map[string]struct{}
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: 8,
+		MaxLength: 256,
+	}
+}
+
+// LoadBreachedPasswords reads a list of known breached passwords, one per
+// line, from path.
+func (p *PasswordPolicy) LoadBreachedPasswords(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line != "" {
+			breached[line] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p.breached = breached
+	return nil
+}
+
+func (p PasswordPolicy) Validate(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w, it must be at least %d characters long", ErrPasswordTooShort, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w, it can't be longer than %d characters", ErrPasswordTooLong, p.MaxLength)
+	}
+
+	if _, ok := p.breached[password]; ok {
+		return ErrBreachedPassword
+	}
+
+	return nil
+}
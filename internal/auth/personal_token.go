@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Personal access tokens are opaque and carry a prefix, so they can be told
+// apart from JWTs in the Authorization header and spotted by secret scanners.
+const personalTokenPrefix = "chirpy_pat_"
+
+// MakePersonalAccessToken returns a new token and the hash to store for it.
+// The token itself is only shown once to the user.
+func MakePersonalAccessToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	token := personalTokenPrefix + hex.EncodeToString(raw)
+	return token, HashToken(token), nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, personalTokenPrefix)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type Scope string
+
+const (
+	ScopeChirpsRead     Scope = "chirps:read"
+	ScopeChirpsWrite    Scope = "chirps:write"
+	ScopeProfileRead    Scope = "profile:read"
+	ScopeProfileWrite   Scope = "profile:write"
+	ScopeWebhooksManage Scope = "webhooks:manage"
+	ScopeMessagesRead   Scope = "messages:read"
+	ScopeMessagesWrite  Scope = "messages:write"
+	// ScopeTokensManage and ScopeAdmin are never granted to delegated
+	// credentials, so only a user's own session can manage tokens, grant
+	// OAuth consent or use admin endpoints.
+	ScopeTokensManage Scope = "tokens:manage"
+	ScopeAdmin        Scope = "admin"
+)
+
+// GrantableScopes lists the scopes that can be attached to delegated
+// credentials such as personal access tokens.
+var GrantableScopes = []Scope{
+	ScopeChirpsRead,
+	ScopeChirpsWrite,
+	ScopeProfileRead,
+	ScopeProfileWrite,
+	ScopeWebhooksManage,
+	ScopeMessagesRead,
+	ScopeMessagesWrite,
+}
+
+// ParseScopes validates a list of scope names and removes duplicates.
+func ParseScopes(names []string) ([]Scope, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+
+	scopes := make([]Scope, 0, len(names))
+	for _, name := range names {
+		scope := Scope(strings.TrimSpace(name))
+		if !slices.Contains(GrantableScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// ScopeStrings converts scopes back to the representation stored in the database.
+func ScopeStrings(scopes []Scope) []string {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+
+	return names
+}
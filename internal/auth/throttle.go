@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"time"
+)
+
+// ThrottlePolicy decides how long a client has to wait after failed login
+// attempts. The first FreeAttempts failures are not delayed, after that the
+// delay doubles with every failure up to MaxDelay. Once LockoutThreshold
+// failures are reached the key is locked for LockoutDuration.
+type ThrottlePolicy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// Failures older than ResetAfter are forgotten
+	ResetAfter time.Duration
+}
+
+func DefaultAccountThrottlePolicy() ThrottlePolicy {
+	return ThrottlePolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  30 * time.Minute,
+		ResetAfter:       24 * time.Hour,
+	}
+}
+
+// DefaultIPThrottlePolicy is more lenient than the account policy, since
+// many users can share an address.
+func DefaultIPThrottlePolicy() ThrottlePolicy {
+	return ThrottlePolicy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+		ResetAfter:       time.Hour,
+	}
+}
+
+// RetryAt returns the earliest time a new attempt is allowed after failures
+// failed attempts, the last one at lastFailure.
+func (p ThrottlePolicy) RetryAt(failures int, lastFailure time.Time) time.Time {
+	if failures >= p.LockoutThreshold {
+		return lastFailure.Add(p.LockoutDuration)
+	}
+
+	if failures < p.FreeAttempts {
+		return lastFailure
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return lastFailure.Add(min(delay, p.MaxDelay))
+}
+
+// ResetCutoff returns the time before which failures are forgotten.
+func (p ThrottlePolicy) ResetCutoff(now time.Time) time.Time {
+	return now.Add(-p.ResetAfter)
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -13,12 +14,45 @@ import (
 	"github.com/google/uuid"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
-	claims := &jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Hour)),
-		Subject:   userID.String(),
+// Claims are the claims carried by Chirpy access tokens. Scope and ClientID
+// are only set on tokens issued to third-party clients through OAuth.
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// Delegated reports whether the token was issued to a third-party client.
+func (c *Claims) Delegated() bool {
+	return c.ClientID != ""
+}
+
+// MakeJWT signs an access token for userID. The requested lifetime is
+// clamped by the policy, so callers can pass the value sent by the client.
+func MakeJWT(userID uuid.UUID, tokenSecret string, policy TokenPolicy, expiresIn time.Duration) (string, error) {
+	return signJWT(&Claims{}, userID, tokenSecret, policy, policy.ClampAccessTTL(expiresIn))
+}
+
+// MakeDelegatedJWT signs an access token issued to an OAuth client. The
+// token ID lets the server revoke it before it expires.
+func MakeDelegatedJWT(userID uuid.UUID, tokenSecret string, policy TokenPolicy, tokenID uuid.UUID, clientID uuid.UUID, scopes []Scope, expiresIn time.Duration) (string, error) {
+	claims := &Claims{
+		Scope:    FormatScopes(scopes),
+		ClientID: clientID.String(),
+	}
+	claims.ID = tokenID.String()
+
+	return signJWT(claims, userID, tokenSecret, policy, expiresIn)
+}
+
+func signJWT(claims *Claims, userID uuid.UUID, tokenSecret string, policy TokenPolicy, expiresIn time.Duration) (string, error) {
+	now := policy.clock()
+	claims.Issuer = policy.Issuer
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(expiresIn))
+	claims.Subject = userID.String()
+	if policy.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{policy.Audience}
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -30,24 +64,43 @@ func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
 	return ss, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
+func ValidateJWT(tokenString, tokenSecret string, policy TokenPolicy) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, tokenSecret, policy)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(claims.Subject)
+}
+
+// ParseJWT validates the token like ValidateJWT and returns all of its claims.
+func ParseJWT(tokenString, tokenSecret string, policy TokenPolicy) (*Claims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(policy.Issuer),
+		jwt.WithTimeFunc(policy.clock),
+	}
+	if policy.Audience != "" {
+		options = append(options, jwt.WithAudience(policy.Audience))
+	}
+
+	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(tokenSecret), nil
-	})
+	}, options...)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
 	if !token.Valid {
-		return uuid.Nil, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	userID, err := uuid.Parse(claims.Subject)
-	if err != nil {
-		return uuid.Nil, err
+	if _, err := uuid.Parse(claims.Subject); err != nil {
+		return nil, err
 	}
 
-	return userID, nil
+	return claims, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -61,6 +114,13 @@ func GetBearerToken(headers http.Header) (string, error) {
 	return token[1], nil
 }
 
+// HashToken returns the digest stored in the database for opaque tokens.
+// They are random and long enough that a plain SHA-256 is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func MakeRefreshToken() (string, error) {
 	token := make([]byte, 32)
 	if _, err := rand.Read(token); err != nil {
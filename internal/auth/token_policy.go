@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+// TokenPolicy controls the lifetime and the registered claims of the
+// tokens issued by the server.
+type TokenPolicy struct {
+	AccessTokenTTL     time.Duration // used when the client doesn't ask for a lifetime
+	MaxAccessTokenTTL  time.Duration // upper bound for client requested lifetimes
+	RefreshTokenTTL    time.Duration
+	RefreshIdleTimeout time.Duration // zero disables the idle check
+	Issuer             string
+	Audience           string
+
+	now func() time.Time
+}
+
+func DefaultTokenPolicy() TokenPolicy {
+	return TokenPolicy{
+		AccessTokenTTL:     time.Hour,
+		MaxAccessTokenTTL:  time.Hour,
+		RefreshTokenTTL:    time.Hour * 24 * 60,
+		RefreshIdleTimeout: 0,
+		Issuer:             "chirpy",
+		Audience:           "",
+	}
+}
+
+// LoadTokenPolicy builds a policy from the environment, falling back to
+// the defaults for every variable that is not set.
+func LoadTokenPolicy(getenv func(string) string) (TokenPolicy, error) {
+	policy := DefaultTokenPolicy()
+
+	durations := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"ACCESS_TOKEN_TTL", &policy.AccessTokenTTL},
+		{"ACCESS_TOKEN_MAX_TTL", &policy.MaxAccessTokenTTL},
+		{"REFRESH_TOKEN_TTL", &policy.RefreshTokenTTL},
+		{"REFRESH_TOKEN_IDLE_TIMEOUT", &policy.RefreshIdleTimeout},
+	}
+	for _, d := range durations {
+		value := getenv(d.key)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return TokenPolicy{}, fmt.Errorf("invalid duration for %s: %q", d.key, value)
+		}
+		*d.dst = parsed
+	}
+
+	if issuer := getenv("JWT_ISSUER"); issuer != "" {
+		policy.Issuer = issuer
+	}
+	policy.Audience = getenv("JWT_AUDIENCE")
+
+	if policy.AccessTokenTTL <= 0 || policy.MaxAccessTokenTTL <= 0 || policy.RefreshTokenTTL <= 0 {
+		return TokenPolicy{}, fmt.Errorf("token lifetimes must be greater than zero")
+	}
+	if policy.AccessTokenTTL > policy.MaxAccessTokenTTL {
+		return TokenPolicy{}, fmt.Errorf("ACCESS_TOKEN_TTL can't be greater than ACCESS_TOKEN_MAX_TTL")
+	}
+
+	return policy, nil
+}
+
+// ClampAccessTTL returns the lifetime to use for an access token when the
+// client asked for requested. Zero or negative values select the default.
+func (p TokenPolicy) ClampAccessTTL(requested time.Duration) time.Duration {
+	if requested <= 0 {
+		return p.AccessTokenTTL
+	}
+
+	return min(requested, p.MaxAccessTokenTTL)
+}
+
+// RefreshTokenExpiry returns the absolute expiry of a refresh token issued now.
+func (p TokenPolicy) RefreshTokenExpiry() time.Time {
+	return p.clock().Add(p.RefreshTokenTTL)
+}
+
+// RefreshIdleCutoff returns the oldest last-use time a refresh token may have
+// and still be accepted. With no idle timeout every token passes.
+func (p TokenPolicy) RefreshIdleCutoff() time.Time {
+	if p.RefreshIdleTimeout <= 0 {
+		return time.Time{}
+	}
+
+	return p.clock().Add(-p.RefreshIdleTimeout)
+}
+
+func (p TokenPolicy) clock() time.Time {
+	if p.now != nil {
+		return p.now().UTC()
+	}
+
+	return time.Now().UTC()
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WebhookTimestampHeader = "X-Polka-Timestamp"
+	WebhookSignatureHeader = "X-Polka-Signature"
+	webhookSignatureScheme = "v1="
+)
+
+var (
+	ErrMissingSignature = errors.New("missing webhook signature headers")
+	ErrStaleTimestamp   = errors.New("webhook timestamp outside of the tolerance window")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// WebhookVerifier checks the HMAC-SHA256 signature sent with webhooks. The
+// signature covers "<timestamp>.<raw body>", and requests whose timestamp is
+// outside of the tolerance window are rejected so they can't be replayed
+// later. More than one secret can be active while a secret is rotated.
+type WebhookVerifier struct {
+	secrets   [][]byte
+	tolerance time.Duration
+	now       func() time.Time
+}
+
+func NewWebhookVerifier(secrets []string, tolerance time.Duration) WebhookVerifier {
+	v := WebhookVerifier{tolerance: tolerance}
+	for _, secret := range secrets {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			v.secrets = append(v.secrets, []byte(secret))
+		}
+	}
+
+	return v
+}
+
+// Verify must be called with the raw body, before it is decoded.
+func (v WebhookVerifier) Verify(headers http.Header, body []byte) error {
+	rawTimestamp := headers.Get(WebhookTimestampHeader)
+	signatures := headers.Get(WebhookSignatureHeader)
+	if rawTimestamp == "" || signatures == "" || len(v.secrets) == 0 {
+		return ErrMissingSignature
+	}
+
+	seconds, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return ErrMissingSignature
+	}
+
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > v.tolerance || age < -v.tolerance {
+		return ErrStaleTimestamp
+	}
+
+	// The header can hold several comma separated signatures
+	for _, signature := range strings.Split(signatures, ",") {
+		signature = strings.TrimSpace(signature)
+		if !strings.HasPrefix(signature, webhookSignatureScheme) {
+			continue
+		}
+
+		received, err := hex.DecodeString(strings.TrimPrefix(signature, webhookSignatureScheme))
+		if err != nil {
+			continue
+		}
+
+		for _, secret := range v.secrets {
+			if hmac.Equal(received, computeWebhookMAC(secret, rawTimestamp, body)) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// SignWebhook returns the value of the signature header for body sent at
+// timestamp.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := computeWebhookMAC([]byte(secret), strconv.FormatInt(timestamp.Unix(), 10), body)
+	return webhookSignatureScheme + hex.EncodeToString(mac)
+}
+
+func computeWebhookMAC(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
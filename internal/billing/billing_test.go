@@ -0,0 +1,131 @@
+package billing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("upgrade starts a billing period", func(t *testing.T) {
+		state, err := Apply(nil, Event{Type: EventUserUpgraded}, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if state.Status != StatusActive || state.Plan != DefaultPlan || !state.CurrentPeriodEnd.Equal(now.Add(BillingPeriod)) {
+			t.Fatalf("unexpected state after upgrade: %+v", state)
+		}
+	})
+
+	t.Run("period end sent by Polka is used", func(t *testing.T) {
+		periodEnd := now.AddDate(1, 0, 0)
+		state, err := Apply(nil, Event{Type: EventUserUpgraded, Plan: "chirpy_red_yearly", CurrentPeriodEnd: periodEnd}, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if state.Plan != "chirpy_red_yearly" || !state.CurrentPeriodEnd.Equal(periodEnd) {
+			t.Fatalf("unexpected state after upgrade: %+v", state)
+		}
+	})
+
+	t.Run("failed payment starts the grace period once", func(t *testing.T) {
+		active := State{Plan: DefaultPlan, Status: StatusActive, CurrentPeriodEnd: now.Add(time.Hour)}
+
+		pastDue, err := Apply(&active, Event{Type: EventPaymentFailed}, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pastDue.Status != StatusPastDue || !pastDue.GracePeriodEnd.Equal(now.Add(GracePeriod)) {
+			t.Fatalf("unexpected state after failed payment: %+v", pastDue)
+		}
+
+		retried, err := Apply(&pastDue, Event{Type: EventPaymentFailed}, now.Add(48*time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !retried.GracePeriodEnd.Equal(pastDue.GracePeriodEnd) {
+			t.Fatalf("grace period shouldn't be extended by retries, got %v", retried.GracePeriodEnd)
+		}
+	})
+
+	t.Run("renewal clears the grace period", func(t *testing.T) {
+		pastDue := State{Plan: DefaultPlan, Status: StatusPastDue, CurrentPeriodEnd: now, GracePeriodEnd: now.Add(GracePeriod)}
+
+		renewed, err := Apply(&pastDue, Event{Type: EventSubscriptionRenewed}, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if renewed.Status != StatusActive || !renewed.GracePeriodEnd.IsZero() || !renewed.CurrentPeriodEnd.Equal(now.Add(BillingPeriod)) {
+			t.Fatalf("unexpected state after renewal: %+v", renewed)
+		}
+	})
+
+	t.Run("renewal never shortens the period", func(t *testing.T) {
+		active := State{Plan: DefaultPlan, Status: StatusActive, CurrentPeriodEnd: now.AddDate(0, 2, 0)}
+
+		renewed, err := Apply(&active, Event{Type: EventSubscriptionRenewed, CurrentPeriodEnd: now.AddDate(0, 1, 0)}, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !renewed.CurrentPeriodEnd.Equal(active.CurrentPeriodEnd) {
+			t.Fatalf("expected period end %v, got %v", active.CurrentPeriodEnd, renewed.CurrentPeriodEnd)
+		}
+	})
+
+	t.Run("downgrade and refund end access now", func(t *testing.T) {
+		active := State{Plan: DefaultPlan, Status: StatusActive, CurrentPeriodEnd: now.Add(BillingPeriod)}
+
+		for event, status := range map[string]string{EventUserDowngraded: StatusCanceled, EventPaymentRefunded: StatusRefunded} {
+			state, err := Apply(&active, Event{Type: event}, now)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", event, err)
+			}
+			if state.Status != status || !state.CurrentPeriodEnd.Equal(now) {
+				t.Fatalf("%s: unexpected state: %+v", event, state)
+			}
+		}
+	})
+
+	t.Run("events need an existing subscription", func(t *testing.T) {
+		for _, event := range []string{EventSubscriptionRenewed, EventPaymentFailed, EventUserDowngraded, EventPaymentRefunded} {
+			if _, err := Apply(nil, Event{Type: event}, now); err != ErrNoSubscription {
+				t.Fatalf("%s: expected ErrNoSubscription, got %v", event, err)
+			}
+		}
+	})
+
+	t.Run("failed payment on a canceled subscription", func(t *testing.T) {
+		canceled := State{Plan: DefaultPlan, Status: StatusCanceled, CurrentPeriodEnd: now}
+		if _, err := Apply(&canceled, Event{Type: EventPaymentFailed}, now); err != ErrNotActive {
+			t.Fatalf("expected ErrNotActive, got %v", err)
+		}
+	})
+
+	t.Run("unknown event", func(t *testing.T) {
+		if _, err := Apply(nil, Event{Type: "user.teleported"}, now); err != ErrUnknownEvent {
+			t.Fatalf("expected ErrUnknownEvent, got %v", err)
+		}
+	})
+}
+
+func TestEntitlementsFor(t *testing.T) {
+	free := EntitlementsFor(false)
+	red := EntitlementsFor(true)
+
+	if free.CanEditChirps || free.ProfileBadge != "" {
+		t.Fatalf("free users shouldn't get premium perks: %+v", free)
+	}
+	if free.MaxChirpLength != 140 {
+		t.Fatalf("expected free chirps to be limited to 140 characters, got %d", free.MaxChirpLength)
+	}
+
+	if !red.CanEditChirps || red.ProfileBadge == "" {
+		t.Fatalf("Chirpy Red members should get premium perks: %+v", red)
+	}
+	if red.MaxChirpLength <= free.MaxChirpLength || red.MaxMediaPerChirp <= free.MaxMediaPerChirp || red.RequestsPerMinute <= free.RequestsPerMinute {
+		t.Fatalf("Chirpy Red limits should be higher than free ones: %+v vs %+v", red, free)
+	}
+}
@@ -0,0 +1,37 @@
+package billing
+
+// Entitlements are the limits and features available to a user. Handlers
+// check entitlements instead of membership, so a new perk only needs a new
+// field here and a check where it's used.
+type Entitlements struct {
+	MaxChirpLength    int
+	MaxMediaPerChirp  int
+	CanEditChirps     bool
+	RequestsPerMinute int
+	ProfileBadge      string // empty for no badge
+}
+
+var (
+	freeEntitlements = Entitlements{
+		MaxChirpLength:    140,
+		MaxMediaPerChirp:  1,
+		CanEditChirps:     false,
+		RequestsPerMinute: 30,
+	}
+
+	chirpyRedEntitlements = Entitlements{
+		MaxChirpLength:    1000,
+		MaxMediaPerChirp:  4,
+		CanEditChirps:     true,
+		RequestsPerMinute: 120,
+		ProfileBadge:      "chirpy_red",
+	}
+)
+
+func EntitlementsFor(isChirpyRed bool) Entitlements {
+	if isChirpyRed {
+		return chirpyRedEntitlements
+	}
+
+	return freeEntitlements
+}
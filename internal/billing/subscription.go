@@ -0,0 +1,122 @@
+// Package billing implements the Chirpy Red subscription lifecycle driven by
+// Polka events. Whether a subscription currently grants membership is decided
+// in SQL (see the IsChirpyRed query), so it can't drift between instances.
+package billing
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	StatusActive   = "active"
+	StatusPastDue  = "past_due"
+	StatusCanceled = "canceled"
+	StatusRefunded = "refunded"
+	StatusExpired  = "expired"
+)
+
+const (
+	EventUserUpgraded        = "user.upgraded"
+	EventUserDowngraded      = "user.downgraded"
+	EventSubscriptionRenewed = "subscription.renewed"
+	EventPaymentFailed       = "payment.failed"
+	EventPaymentRefunded     = "payment.refunded"
+	// EventExpired is recorded by the nightly expiry job, Polka doesn't send it
+	EventExpired = "subscription.expired"
+)
+
+const (
+	DefaultPlan = "chirpy_red_monthly"
+	// BillingPeriod is used when Polka doesn't send the end of the period
+	BillingPeriod = 30 * 24 * time.Hour
+	// GracePeriod keeps the membership after a failed payment, so the user
+	// has time to fix their payment method
+	GracePeriod = 7 * 24 * time.Hour
+)
+
+var (
+	ErrUnknownEvent   = errors.New("unknown subscription event")
+	ErrNoSubscription = errors.New("user has no subscription")
+	ErrNotActive      = errors.New("subscription is not active")
+)
+
+// State is the part of a subscription changed by events.
+type State struct {
+	Plan             string
+	Status           string
+	CurrentPeriodEnd time.Time
+	GracePeriodEnd   time.Time // zero unless past due
+}
+
+// Event is a subscription change reported by Polka.
+type Event struct {
+	Type             string
+	Plan             string
+	CurrentPeriodEnd time.Time // optional
+}
+
+// Apply returns the state after event. current is nil when the user never
+// had a subscription.
+func Apply(current *State, event Event, now time.Time) (State, error) {
+	periodEnd := event.CurrentPeriodEnd
+	if periodEnd.IsZero() {
+		periodEnd = now.Add(BillingPeriod)
+	}
+
+	switch event.Type {
+	case EventUserUpgraded:
+		plan := event.Plan
+		if plan == "" {
+			plan = DefaultPlan
+		}
+		return State{Plan: plan, Status: StatusActive, CurrentPeriodEnd: periodEnd}, nil
+
+	case EventSubscriptionRenewed:
+		if current == nil {
+			return State{}, ErrNoSubscription
+		}
+		next := *current
+		next.Status = StatusActive
+		next.GracePeriodEnd = time.Time{}
+		// Never shorten a period that was already paid for
+		if periodEnd.After(current.CurrentPeriodEnd) {
+			next.CurrentPeriodEnd = periodEnd
+		}
+		if event.Plan != "" {
+			next.Plan = event.Plan
+		}
+		return next, nil
+
+	case EventPaymentFailed:
+		if current == nil {
+			return State{}, ErrNoSubscription
+		}
+		if current.Status != StatusActive && current.Status != StatusPastDue {
+			return State{}, ErrNotActive
+		}
+		next := *current
+		if next.Status != StatusPastDue {
+			// The grace period starts at the first failure, retries don't extend it
+			next.Status = StatusPastDue
+			next.GracePeriodEnd = now.Add(GracePeriod)
+		}
+		return next, nil
+
+	case EventUserDowngraded, EventPaymentRefunded:
+		if current == nil {
+			return State{}, ErrNoSubscription
+		}
+		next := *current
+		next.Status = StatusCanceled
+		if event.Type == EventPaymentRefunded {
+			next.Status = StatusRefunded
+		}
+		next.GracePeriodEnd = time.Time{}
+		// Access ends right away, the user is no longer paying for it
+		next.CurrentPeriodEnd = now
+		return next, nil
+	}
+
+	return State{}, ErrUnknownEvent
+}
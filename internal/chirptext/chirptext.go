@@ -0,0 +1,133 @@
+// Package chirptext normalizes and measures the text of chirps.
+//
+// Length is counted in grapheme clusters, what a reader sees as one
+// character, so an emoji made of several code points counts as one. Links
+// count as a fixed length whatever their real length, like on other
+// platforms, because clients shorten them when displaying.
+package chirptext
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// URLLength is the length counted for every link.
+const URLLength = 23
+
+var urlPattern = regexp.MustCompile(`https?://[^\s]+`)
+
+// Punctuation after a link is usually part of the sentence, not the link
+const urlTrailingPunctuation = ".,!?;:'\")]}"
+
+const (
+	zeroWidthJoiner    = '\u200d'
+	zeroWidthNonJoiner = '\u200c'
+)
+
+// Normalize returns text in NFC with control and invisible formatting
+// characters removed. Line breaks and tabs are kept, and so are the
+// characters that change how emoji render: the zero width joiners inside
+// emoji sequences and the tags used in subdivision flags. Joiners anywhere
+// else would only hide words from the content filter.
+func Normalize(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || r == zeroWidthNonJoiner {
+			return -1
+		}
+		if unicode.Is(unicode.Cf, r) && r != zeroWidthJoiner && !isTag(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	return norm.NFC.String(removeStrayJoiners(text))
+}
+
+// removeStrayJoiners removes the zero width joiners that don't join two
+// emoji. A joiner between emoji is in the middle of a grapheme cluster, any
+// other one ends the cluster it is in.
+func removeStrayJoiners(text string) string {
+	if !strings.ContainsRune(text, zeroWidthJoiner) {
+		return text
+	}
+
+	var b strings.Builder
+	state := -1
+	for text != "" {
+		var cluster string
+		cluster, text, _, state = uniseg.FirstGraphemeClusterInString(text, state)
+		b.WriteString(strings.TrimRight(cluster, string(zeroWidthJoiner)))
+	}
+
+	return b.String()
+}
+
+// Tags spell out the region in subdivision flags like England's
+func isTag(r rune) bool {
+	return r >= 0xE0000 && r <= 0xE007F
+}
+
+// URL is a link found in the text. Start and End are offsets in code
+// points, which clients can use to highlight it.
+type URL struct {
+	URL   string
+	Start int
+	End   int
+}
+
+// URLs returns the links in text.
+func URLs(text string) []URL {
+	var urls []URL
+	points := 0
+	last := 0
+	for _, span := range urlSpans(text) {
+		points += utf8.RuneCountInString(text[last:span[0]])
+		start := points
+		points += utf8.RuneCountInString(text[span[0]:span[1]])
+		last = span[1]
+
+		urls = append(urls, URL{URL: text[span[0]:span[1]], Start: start, End: points})
+	}
+
+	return urls
+}
+
+// Length returns the length of text as shown to the user.
+func Length(text string) int {
+	length := 0
+	last := 0
+	for _, span := range urlSpans(text) {
+		length += uniseg.GraphemeClusterCount(text[last:span[0]]) + URLLength
+		last = span[1]
+	}
+
+	return length + uniseg.GraphemeClusterCount(text[last:])
+}
+
+// urlSpans returns the byte offsets of the links in text.
+func urlSpans(text string) [][2]int {
+	var spans [][2]int
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		end := loc[1]
+		for end > loc[0] && strings.ContainsRune(urlTrailingPunctuation, rune(text[end-1])) {
+			end--
+		}
+		spans = append(spans, [2]int{loc[0], end})
+	}
+
+	return spans
+}
+
+// IsBlank reports whether text has nothing but whitespace.
+func IsBlank(text string) bool {
+	return strings.TrimFunc(text, unicode.IsSpace) == ""
+}
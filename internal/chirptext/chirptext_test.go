@@ -0,0 +1,89 @@
+package chirptext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"composes accents", "cafe\u0301", "caf\u00e9"},
+		{"removes zero width spaces", "ker\u200bfuf\ufefffle", "kerfuffle"},
+		{"removes control characters", "hello\x00\x07 world", "hello world"},
+		{"removes bidi overrides", "\u202eevil", "evil"},
+		{"keeps line breaks", "one\r\ntwo\tthree", "one\ntwo\tthree"},
+		{"keeps emoji joiners", "\U0001F469\u200d\U0001F467", "\U0001F469\u200d\U0001F467"},
+		{"removes joiners between letters", "for\u200dnax for\u200cnax", "fornax fornax"},
+		{"removes joiners after emoji", "\U0001F469\u200dnax", "\U0001F469nax"},
+		{"keeps subdivision flags", "\U0001F3F4\U000E0067\U000E0062\U000E0065\U000E006E\U000E0067\U000E007F", "\U0001F3F4\U000E0067\U000E0062\U000E0065\U000E006E\U000E0067\U000E007F"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Normalize(tc.input); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"ascii", "hello", 5},
+		{"accents", "cafe\u0301", 4},
+		{"emoji", strings.Repeat("🎉", 50), 50},
+		{"emoji sequence", "\U0001F469\u200d\U0001F469\u200d\U0001F467", 1},
+		{"flag", "🇺🇾", 1},
+		{"url", "see https://example.com/a/very/long/path/that/goes/on?and=on", 4 + URLLength},
+		{"url with punctuation", "(https://example.com).", 1 + URLLength + 2},
+		{"several urls", "http://a.io http://b.io", 2*URLLength + 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Length(tc.input); got != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestIsBlank(t *testing.T) {
+	for _, text := range []string{"", "   ", "\n\t", "\u3000"} {
+		if !IsBlank(text) {
+			t.Errorf("expected %q to be blank", text)
+		}
+	}
+	if IsBlank(" a ") {
+		t.Errorf("expected text not to be blank")
+	}
+}
+
+func TestURLs(t *testing.T) {
+	got := URLs("🎉 read https://example.com/post, then http://chirpy.dev!")
+	want := []URL{
+		{URL: "https://example.com/post", Start: 7, End: 31},
+		{URL: "http://chirpy.dev", Start: 38, End: 55},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d urls, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], got[i])
+		}
+	}
+
+	if urls := URLs("no links here"); len(urls) != 0 {
+		t.Errorf("expected no urls, got %+v", urls)
+	}
+}
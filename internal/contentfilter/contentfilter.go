@@ -0,0 +1,372 @@
+// Package contentfilter matches text against the moderation word lists.
+//
+// Text goes through a pipeline: it is split into words, each word is
+// normalized (Unicode case folding, accents removed, invisible characters
+// dropped and common character substitutions undone) and the normalized
+// words are matched against the rules, which can be single words or
+// phrases. Only whole words match, so a rule for "ass" doesn't match
+// "class". Letters repeated three or more times ("kerrrfuffle") are also
+// tried collapsed, letters written twice are left alone because plenty of
+// words differ only by one (a rule for "hell" doesn't match "hel").
+package contentfilter
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+type Action string
+
+const (
+	// ActionMask replaces the matched words with asterisks.
+	ActionMask Action = "mask"
+	// ActionReject refuses the whole text.
+	ActionReject Action = "reject"
+	// ActionFlag accepts the text as is and reports it for review.
+	ActionFlag Action = "flag"
+)
+
+func (a Action) Valid() bool {
+	return a == ActionMask || a == ActionReject || a == ActionFlag
+}
+
+const mask = "****"
+
+type Rule struct {
+	ID     uuid.UUID
+	Term   string
+	Action Action
+}
+
+// Match is a rule found in the text. Start and End are byte offsets in the
+// original text.
+type Match struct {
+	Rule  Rule
+	Start int
+	End   int
+}
+
+type Result struct {
+	// Text has the words matched by mask rules replaced.
+	Text    string
+	Matches []Match
+}
+
+func (r Result) Rejected() bool {
+	for _, match := range r.Matches {
+		if match.Rule.Action == ActionReject {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Result) Flagged() []Match {
+	var flagged []Match
+	for _, match := range r.Matches {
+		if match.Rule.Action == ActionFlag {
+			flagged = append(flagged, match)
+		}
+	}
+
+	return flagged
+}
+
+type compiledRule struct {
+	rule  Rule
+	words []string
+}
+
+// Filter is an immutable set of rules.
+type Filter struct {
+	// Rules indexed by their first normalized word
+	rules map[string][]compiledRule
+}
+
+func New(rules []Rule) *Filter {
+	f := &Filter{rules: make(map[string][]compiledRule)}
+	for _, rule := range rules {
+		var words []string
+		for _, t := range tokenize(rule.Term) {
+			words = append(words, t.word)
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		f.rules[words[0]] = append(f.rules[words[0]], compiledRule{rule: rule, words: words})
+	}
+
+	return f
+}
+
+func (f *Filter) Apply(text string) Result {
+	tokens := tokenize(text)
+
+	var matches []Match
+	for i, t := range tokens {
+		for _, form := range t.forms {
+			for _, rule := range f.rules[form] {
+				if i+len(rule.words) > len(tokens) {
+					continue
+				}
+
+				matched := true
+				for j, word := range rule.words[1:] {
+					if !tokens[i+1+j].is(word) {
+						matched = false
+						break
+					}
+				}
+				if matched {
+					matches = append(matches, Match{
+						Rule:  rule.rule,
+						Start: t.start,
+						End:   tokens[i+len(rule.words)-1].end,
+					})
+				}
+			}
+		}
+	}
+
+	matches = append(matches, f.spacedOutMatches(tokens)...)
+
+	return Result{Text: maskMatches(text, matches), Matches: matches}
+}
+
+func maskMatches(text string, matches []Match) string {
+	var spans []Match
+	for _, match := range matches {
+		if match.Rule.Action == ActionMask {
+			spans = append(spans, match)
+		}
+	}
+	if len(spans) == 0 {
+		return text
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		if span.Start < last {
+			// Overlaps a span that was already masked
+			if span.End > last {
+				last = span.End
+			}
+			continue
+		}
+
+		b.WriteString(text[last:span.Start])
+		b.WriteString(mask)
+		last = span.End
+	}
+	b.WriteString(text[last:])
+
+	return b.String()
+}
+
+type token struct {
+	word string
+	// forms are the ways the word is matched: as written and with the
+	// letters it repeats collapsed
+	forms      []string
+	start, end int
+}
+
+func (t token) is(word string) bool {
+	for _, form := range t.forms {
+		if form == word {
+			return true
+		}
+	}
+	return false
+}
+
+// Characters commonly used in place of letters
+var substitutions = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+}
+
+// Invisible formatting characters, like zero width joiners, are part of the
+// word they are in so they can't be used to split it
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || unicode.Is(unicode.Cf, r) || r == '@' || r == '$'
+}
+
+// tokenize splits text into normalized words.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = appendToken(tokens, text, start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = appendToken(tokens, text, start, len(text))
+	}
+
+	return tokens
+}
+
+func appendToken(tokens []token, text string, start, end int) []token {
+	// A leading @ or $ is a mention or a price, not a substituted letter
+	for start < end && (text[start] == '@' || text[start] == '$') {
+		start++
+	}
+	if start == end {
+		return tokens
+	}
+
+	word := normalize(text[start:end])
+	if word == "" {
+		return tokens
+	}
+
+	return append(tokens, token{word: word, forms: forms(word), start: start, end: end})
+}
+
+// spacedOutMatches finds single-word rules spelled out as separate letters
+// ("k e r f" or "k.e.r.f"), a common way to get around filters. Any part of
+// a run of single letters can match, so "a k.e.r.f" still matches "kerf".
+func (f *Filter) spacedOutMatches(tokens []token) []Match {
+	var matches []Match
+	for i := 0; i < len(tokens); {
+		j := i
+		for j < len(tokens) && utf8.RuneCountInString(tokens[j].word) == 1 {
+			j++
+		}
+
+		for a := i; a < j; a++ {
+			var word strings.Builder
+			for b := a; b < j; b++ {
+				word.WriteString(tokens[b].word)
+				if b-a < 2 {
+					continue
+				}
+
+				for _, joined := range forms(word.String()) {
+					for _, rule := range f.rules[joined] {
+						if len(rule.words) == 1 {
+							matches = append(matches, Match{Rule: rule.rule, Start: tokens[a].start, End: tokens[b].end})
+						}
+					}
+				}
+			}
+		}
+
+		i = max(j, i+1)
+	}
+
+	return matches
+}
+
+var (
+	folder     = cases.Fold()
+	stripMarks = transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), runes.Remove(runes.In(unicode.Cf)), norm.NFC)
+)
+
+// normalize folds case, removes accents and invisible characters and undoes
+// substitutions, so "KÉRF\u200dUFF1E" becomes "kerfuffie". Rules are
+// normalized the same way.
+func normalize(word string) string {
+	stripped, _, err := transform.String(stripMarks, word)
+	if err == nil {
+		word = stripped
+	}
+	word = folder.String(word)
+
+	word = strings.Map(func(r rune) rune {
+		if sub, ok := substitutions[r]; ok {
+			return sub
+		}
+		return r
+	}, word)
+
+	return word
+}
+
+// forms returns word and, if it repeats a letter three or more times, word
+// with those runs collapsed to one and to two letters: "kerrrfuffle" and
+// "helllo" are written like that to get around filters.
+func forms(word string) []string {
+	forms := []string{word}
+	for _, keep := range []int{1, 2} {
+		if collapsed := collapseRuns(word, keep); collapsed != forms[len(forms)-1] {
+			forms = append(forms, collapsed)
+		}
+	}
+
+	return forms
+}
+
+// collapseRuns replaces the runs of three or more of the same letter with
+// keep of them.
+func collapseRuns(word string, keep int) string {
+	letters := []rune(word)
+
+	var b strings.Builder
+	for i := 0; i < len(letters); {
+		j := i
+		for j < len(letters) && letters[j] == letters[i] {
+			j++
+		}
+
+		n := j - i
+		if n >= 3 {
+			n = keep
+		}
+		for range n {
+			b.WriteRune(letters[i])
+		}
+		i = j
+	}
+
+	return b.String()
+}
+
+// Pipeline holds the active filter. Rules can be reloaded while it is in
+// use.
+type Pipeline struct {
+	current atomic.Pointer[Filter]
+}
+
+func NewPipeline(rules []Rule) *Pipeline {
+	p := &Pipeline{}
+	p.Load(rules)
+	return p
+}
+
+func (p *Pipeline) Load(rules []Rule) {
+	p.current.Store(New(rules))
+}
+
+func (p *Pipeline) Apply(text string) Result {
+	return p.current.Load().Apply(text)
+}
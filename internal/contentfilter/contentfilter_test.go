@@ -0,0 +1,72 @@
+package contentfilter
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFilter(t *testing.T) {
+	filter := New([]Rule{
+		{ID: uuid.New(), Term: "kerfuffle", Action: ActionMask},
+		{ID: uuid.New(), Term: "sharbert", Action: ActionMask},
+		{ID: uuid.New(), Term: "fornax", Action: ActionReject},
+		{ID: uuid.New(), Term: "buy followers", Action: ActionFlag},
+		{ID: uuid.New(), Term: "ass", Action: ActionReject},
+		{ID: uuid.New(), Term: "hell", Action: ActionMask},
+	})
+
+	tests := []struct {
+		name         string
+		text         string
+		wantText     string
+		wantRejected bool
+		wantFlagged  int
+	}{
+		{name: "clean text", text: "I had something interesting for breakfast", wantText: "I had something interesting for breakfast"},
+		{name: "plain word", text: "This is a kerfuffle opinion", wantText: "This is a **** opinion"},
+		{name: "punctuation", text: "What a Kerfuffle! Really.", wantText: "What a ****! Really."},
+		{name: "newlines", text: "kerfuffle\nsharbert", wantText: "****\n****"},
+		{name: "case folding", text: "KERFUFFLE and ShArBeRt", wantText: "**** and ****"},
+		{name: "accents", text: "kérfüffle", wantText: "****"},
+		{name: "substitutions", text: "sh@rb3rt k3rfuffl3", wantText: "**** ****"},
+		{name: "repeated letters", text: "kerrrfuuuffle", wantText: "****"},
+		{name: "repeated letters kept double", text: "go to helllll", wantText: "go to ****"},
+		{name: "doubled letters aren't collapsed", text: "as good as it gets", wantText: "as good as it gets"},
+		{name: "word one letter short", text: "hel hello", wantText: "hel hello"},
+		{name: "zero width joiner", text: "for\u200dnax", wantText: "for\u200dnax", wantRejected: true},
+		{name: "zero width non-joiner", text: "ker\u200cfuffle", wantText: "****"},
+		{name: "spaced out letters", text: "a k.e.r.f.u.f.f.l.e b", wantText: "a **** b"},
+		{name: "whole words only", text: "kerfuffles sharberts", wantText: "kerfuffles sharberts"},
+		{name: "reject", text: "Fornax is here", wantText: "Fornax is here", wantRejected: true},
+		{name: "flag phrase", text: "Want to BUY   followers?", wantText: "Want to BUY   followers?", wantFlagged: 1},
+		{name: "phrase words apart", text: "buy some followers", wantText: "buy some followers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := filter.Apply(tt.text)
+			if result.Text != tt.wantText {
+				t.Errorf("expected %q, got %q", tt.wantText, result.Text)
+			}
+			if result.Rejected() != tt.wantRejected {
+				t.Errorf("expected rejected = %v", tt.wantRejected)
+			}
+			if len(result.Flagged()) != tt.wantFlagged {
+				t.Errorf("expected %d flagged matches, got %d", tt.wantFlagged, len(result.Flagged()))
+			}
+		})
+	}
+}
+
+func TestPipelineReload(t *testing.T) {
+	pipeline := NewPipeline(nil)
+	if got := pipeline.Apply("kerfuffle").Text; got != "kerfuffle" {
+		t.Fatalf("expected no rules, got %q", got)
+	}
+
+	pipeline.Load([]Rule{{Term: "kerfuffle", Action: ActionMask}})
+	if got := pipeline.Apply("kerfuffle").Text; got != mask {
+		t.Fatalf("expected reloaded rules to apply, got %q", got)
+	}
+}
@@ -7,29 +7,109 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const addChirpMentions = `-- name: AddChirpMentions :exec
+INSERT INTO chirp_mentions (chirp_id, user_id)
+SELECT $1, mentioned
+FROM unnest($2::uuid[]) AS mentioned
+ON CONFLICT DO NOTHING
+`
+
+type AddChirpMentionsParams struct {
+	ChirpID uuid.UUID
+	UserIds []uuid.UUID
+}
+
+func (q *Queries) AddChirpMentions(ctx context.Context, arg AddChirpMentionsParams) error {
+	_, err := q.db.ExecContext(ctx, addChirpMentions, arg.ChirpID, pq.Array(arg.UserIds))
+	return err
+}
+
+const applyContentWarning = `-- name: ApplyContentWarning :one
+UPDATE chirps
+SET content_warning = $2,
+    sensitive = $3,
+    warning_applied_by = $4,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id
+`
+
+type ApplyContentWarningParams struct {
+	ID               uuid.UUID
+	ContentWarning   sql.NullString
+	Sensitive        bool
+	WarningAppliedBy uuid.NullUUID
+}
+
+func (q *Queries) ApplyContentWarning(ctx context.Context, arg ApplyContentWarningParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, applyContentWarning,
+		arg.ID,
+		arg.ContentWarning,
+		arg.Sensitive,
+		arg.WarningAppliedBy,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		pq.Array(&i.MediaUrls),
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.WarningAppliedBy,
+		&i.Visibility,
+		&i.PinnedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+	)
+	return i, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, visibility, reply_to_id)
 VALUES (
     gen_random_uuid (),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body           string
+	UserID         uuid.UUID
+	MediaUrls      []string
+	ContentWarning sql.NullString
+	Sensitive      bool
+	Visibility     string
+	ReplyToID      uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		pq.Array(arg.MediaUrls),
+		arg.ContentWarning,
+		arg.Sensitive,
+		arg.Visibility,
+		arg.ReplyToID,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,27 +117,51 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		pq.Array(&i.MediaUrls),
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.WarningAppliedBy,
+		&i.Visibility,
+		&i.PinnedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
 	)
 	return i, err
 }
 
-const deleteChirp = `-- name: DeleteChirp :exec
-DELETE FROM chirps
-WHERE id = $1
+const getChirp = `-- name: GetChirp :one
+SELECT id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id FROM chirps
+WHERE id = $1 AND deleted_at IS NULL
 `
 
-func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteChirp, id)
-	return err
+func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		pq.Array(&i.MediaUrls),
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.WarningAppliedBy,
+		&i.Visibility,
+		&i.PinnedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+	)
+	return i, err
 }
 
-const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const getChirpIncludingDeleted = `-- name: GetChirpIncludingDeleted :one
+SELECT id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id FROM chirps
 WHERE id = $1
 `
 
-func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirp, id)
+func (q *Queries) GetChirpIncludingDeleted(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpIncludingDeleted, id)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -65,17 +169,35 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		pq.Array(&i.MediaUrls),
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.WarningAppliedBy,
+		&i.Visibility,
+		&i.PinnedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
 	)
 	return i, err
 }
 
 const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id FROM chirps
+WHERE deleted_at IS NULL
+    AND chirp_visible_to(id, user_id, visibility, $1)
+    AND ($2::bool IS NULL OR sensitive = $2)
+    AND ($3::bool IS NULL OR (content_warning IS NOT NULL) = $3)
 ORDER BY created_at ASC
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type GetChirpsParams struct {
+	ViewerID   uuid.NullUUID
+	Sensitive  sql.NullBool
+	HasWarning sql.NullBool
+}
+
+func (q *Queries) GetChirps(ctx context.Context, arg GetChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirps, arg.ViewerID, arg.Sensitive, arg.HasWarning)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +211,14 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			pq.Array(&i.MediaUrls),
+			&i.ContentWarning,
+			&i.Sensitive,
+			&i.WarningAppliedBy,
+			&i.Visibility,
+			&i.PinnedAt,
+			&i.DeletedAt,
+			&i.ReplyToID,
 		); err != nil {
 			return nil, err
 		}
@@ -104,13 +234,86 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id FROM chirps
 WHERE user_id = $1
-ORDER BY created_at ASC
+    AND deleted_at IS NULL
+    AND chirp_visible_to(id, user_id, visibility, $2)
+    AND ($3::bool IS NULL OR sensitive = $3)
+    AND ($4::bool IS NULL OR (content_warning IS NOT NULL) = $4)
+ORDER BY pinned_at IS NULL, pinned_at DESC, created_at ASC
+`
+
+type GetChirpsByAuthorParams struct {
+	UserID     uuid.UUID
+	ViewerID   uuid.NullUUID
+	Sensitive  sql.NullBool
+	HasWarning sql.NullBool
+}
+
+func (q *Queries) GetChirpsByAuthor(ctx context.Context, arg GetChirpsByAuthorParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor,
+		arg.UserID,
+		arg.ViewerID,
+		arg.Sensitive,
+		arg.HasWarning,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			pq.Array(&i.MediaUrls),
+			&i.ContentWarning,
+			&i.Sensitive,
+			&i.WarningAppliedBy,
+			&i.Visibility,
+			&i.PinnedAt,
+			&i.DeletedAt,
+			&i.ReplyToID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTrashedChirps = `-- name: GetTrashedChirps :many
+SELECT id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id FROM chirps
+WHERE user_id = $1 AND deleted_at > $2
+ORDER BY deleted_at DESC
+LIMIT $3
+OFFSET $4
 `
 
-func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
+type GetTrashedChirpsParams struct {
+	UserID       uuid.UUID
+	DeletedAfter sql.NullTime
+	LimitCount   int32
+	OffsetCount  int32
+}
+
+func (q *Queries) GetTrashedChirps(ctx context.Context, arg GetTrashedChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getTrashedChirps,
+		arg.UserID,
+		arg.DeletedAfter,
+		arg.LimitCount,
+		arg.OffsetCount,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -124,6 +327,14 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			pq.Array(&i.MediaUrls),
+			&i.ContentWarning,
+			&i.Sensitive,
+			&i.WarningAppliedBy,
+			&i.Visibility,
+			&i.PinnedAt,
+			&i.DeletedAt,
+			&i.ReplyToID,
 		); err != nil {
 			return nil, err
 		}
@@ -137,3 +348,271 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const getVisibleChirp = `-- name: GetVisibleChirp :one
+SELECT id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id FROM chirps
+WHERE id = $1
+    AND deleted_at IS NULL
+    AND chirp_visible_to(id, user_id, visibility, $2)
+`
+
+type GetVisibleChirpParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+func (q *Queries) GetVisibleChirp(ctx context.Context, arg GetVisibleChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getVisibleChirp, arg.ID, arg.ViewerID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		pq.Array(&i.MediaUrls),
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.WarningAppliedBy,
+		&i.Visibility,
+		&i.PinnedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+	)
+	return i, err
+}
+
+const isChirpVisible = `-- name: IsChirpVisible :one
+SELECT chirp_visible_to($1, $2, $3::text, $4)::bool AS visible
+`
+
+type IsChirpVisibleParams struct {
+	ChirpID    uuid.UUID
+	AuthorID   uuid.UUID
+	Visibility string
+	ViewerID   uuid.NullUUID
+}
+
+func (q *Queries) IsChirpVisible(ctx context.Context, arg IsChirpVisibleParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isChirpVisible,
+		arg.ChirpID,
+		arg.AuthorID,
+		arg.Visibility,
+		arg.ViewerID,
+	)
+	var visible bool
+	err := row.Scan(&visible)
+	return visible, err
+}
+
+const listDeletedChirps = `-- name: ListDeletedChirps :many
+SELECT id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id FROM chirps
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+LIMIT $1
+OFFSET $2
+`
+
+type ListDeletedChirpsParams struct {
+	LimitCount  int32
+	OffsetCount int32
+}
+
+func (q *Queries) ListDeletedChirps(ctx context.Context, arg ListDeletedChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listDeletedChirps, arg.LimitCount, arg.OffsetCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			pq.Array(&i.MediaUrls),
+			&i.ContentWarning,
+			&i.Sensitive,
+			&i.WarningAppliedBy,
+			&i.Visibility,
+			&i.PinnedAt,
+			&i.DeletedAt,
+			&i.ReplyToID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUserPins = `-- name: LockUserPins :exec
+SELECT 1 FROM users
+WHERE id = $1
+FOR NO KEY UPDATE
+`
+
+func (q *Queries) LockUserPins(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockUserPins, userID)
+	return err
+}
+
+const pinChirp = `-- name: PinChirp :execrows
+UPDATE chirps
+SET pinned_at = NOW()
+WHERE id = $1
+    AND deleted_at IS NULL
+    AND pinned_at IS NULL
+    AND (SELECT count(*) FROM chirps pinned WHERE pinned.user_id = chirps.user_id AND pinned.pinned_at IS NOT NULL) < $2::int
+`
+
+type PinChirpParams struct {
+	ID        uuid.UUID
+	MaxPinned int32
+}
+
+func (q *Queries) PinChirp(ctx context.Context, arg PinChirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, pinChirp, arg.ID, arg.MaxPinned)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedChirps = `-- name: PurgeDeletedChirps :execrows
+DELETE FROM chirps
+WHERE deleted_at < $1
+    AND NOT EXISTS (
+        SELECT 1 FROM chirp_flags
+        WHERE chirp_flags.chirp_id = chirps.id AND chirp_flags.resolved_at IS NULL
+    )
+`
+
+func (q *Queries) PurgeDeletedChirps(ctx context.Context, deletedBefore sql.NullTime) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedChirps, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreChirp = `-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL
+WHERE id = $1 AND deleted_at > $2
+RETURNING id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id
+`
+
+type RestoreChirpParams struct {
+	ID           uuid.UUID
+	DeletedAfter sql.NullTime
+}
+
+func (q *Queries) RestoreChirp(ctx context.Context, arg RestoreChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, restoreChirp, arg.ID, arg.DeletedAfter)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		pq.Array(&i.MediaUrls),
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.WarningAppliedBy,
+		&i.Visibility,
+		&i.PinnedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+	)
+	return i, err
+}
+
+const softDeleteChirp = `-- name: SoftDeleteChirp :one
+UPDATE chirps
+SET deleted_at = NOW(), pinned_at = NULL
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id
+`
+
+func (q *Queries) SoftDeleteChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, softDeleteChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		pq.Array(&i.MediaUrls),
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.WarningAppliedBy,
+		&i.Visibility,
+		&i.PinnedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+	)
+	return i, err
+}
+
+const unpinChirp = `-- name: UnpinChirp :exec
+UPDATE chirps
+SET pinned_at = NULL
+WHERE id = $1
+`
+
+func (q *Queries) UnpinChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, unpinChirp, id)
+	return err
+}
+
+const updateChirp = `-- name: UpdateChirp :one
+UPDATE chirps
+SET body = $2, content_warning = $3, sensitive = $4, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, warning_applied_by, visibility, pinned_at, deleted_at, reply_to_id
+`
+
+type UpdateChirpParams struct {
+	ID             uuid.UUID
+	Body           string
+	ContentWarning sql.NullString
+	Sensitive      bool
+}
+
+func (q *Queries) UpdateChirp(ctx context.Context, arg UpdateChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirp,
+		arg.ID,
+		arg.Body,
+		arg.ContentWarning,
+		arg.Sensitive,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		pq.Array(&i.MediaUrls),
+		&i.ContentWarning,
+		&i.Sensitive,
+		&i.WarningAppliedBy,
+		&i.Visibility,
+		&i.PinnedAt,
+		&i.DeletedAt,
+		&i.ReplyToID,
+	)
+	return i, err
+}
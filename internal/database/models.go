@@ -6,33 +6,318 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type Bookmark struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
 type Chirp struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Body             string
+	UserID           uuid.UUID
+	MediaUrls        []string
+	ContentWarning   sql.NullString
+	Sensitive        bool
+	WarningAppliedBy uuid.NullUUID
+	Visibility       string
+	PinnedAt         sql.NullTime
+	DeletedAt        sql.NullTime
+	ReplyToID        uuid.NullUUID
+}
+
+type ChirpFlag struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	ChirpID    uuid.UUID
+	RuleID     uuid.NullUUID
+	Term       string
+	ResolvedAt sql.NullTime
+	ResolvedBy uuid.NullUUID
+}
+
+type ChirpLike struct {
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type ChirpMention struct {
+	ChirpID uuid.UUID
+	UserID  uuid.UUID
+}
+
+type ChirpViewEvent struct {
+	ChirpID     uuid.UUID
+	Kind        string
+	Viewer      string
+	WindowStart time.Time
+	RolledUp    bool
+}
+
+type ChirpViewRollup struct {
+	ChirpID     uuid.UUID
+	Hour        time.Time
+	Impressions int64
+	Views       int64
+}
+
+type Conversation struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Body      string
-	UserID    uuid.UUID
+	IsGroup   bool
 }
 
-type RefreshToken struct {
-	Token     string
+type ConversationMember struct {
+	ConversationID uuid.UUID
+	UserID         uuid.UUID
+	JoinedAt       time.Time
+	LastReadAt     sql.NullTime
+}
+
+type FilterRule struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Term      string
+	Action    string
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+	Status     string
+}
+
+type LinkPreview struct {
+	Url           string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Status        string
+	Title         string
+	Description   string
+	ImageUrl      string
+	Attempts      int32
+	NextAttemptAt time.Time
+	Error         sql.NullString
+	FetchedAt     sql.NullTime
+}
+
+type List struct {
+	ID        uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	OwnerID   uuid.UUID
+	Name      string
+	IsPrivate bool
+}
+
+type ListMember struct {
+	ListID    uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type ListSubscription struct {
+	ListID    uuid.UUID
 	UserID    uuid.UUID
-	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	CreatedAt time.Time
+}
+
+type LoginThrottle struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+}
+
+type Message struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	ConversationID uuid.UUID
+	SenderID       uuid.UUID
+	BodyEncrypted  []byte
+}
+
+type Notification struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Type       string
+	TargetID   uuid.NullUUID
+	GroupKey   string
+	ActorIds   []uuid.UUID
+	ActorCount int32
+	ReadAt     sql.NullTime
+}
+
+type NotificationPreference struct {
+	UserID  uuid.UUID
+	Type    string
+	Enabled bool
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	CreatedAt     time.Time
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	Scopes       []string
+}
+
+type OauthToken struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	ClientID         uuid.UUID
+	UserID           uuid.UUID
+	Scopes           []string
+	RefreshTokenHash string
+	AccessExpiresAt  time.Time
+	RefreshExpiresAt time.Time
+	RevokedAt        sql.NullTime
+}
+
+type Outbox struct {
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	EventType     string
+	Payload       json.RawMessage
+	PublishedAt   sql.NullTime
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	Seq           int64
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type RefreshToken struct {
+	Token      string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	LastUsedAt time.Time
+}
+
+type Subscription struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	UserID           uuid.UUID
+	Plan             string
+	Status           string
+	CurrentPeriodEnd time.Time
+	GracePeriodEnd   sql.NullTime
+}
+
+type SubscriptionHistory struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	SubscriptionID   uuid.UUID
+	Event            string
+	Status           string
+	CurrentPeriodEnd time.Time
+	WebhookEventID   sql.NullString
 }
 
 type User struct {
+	ID                    uuid.UUID
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	Email                 string
+	HashedPassword        string
+	Role                  string
+	DmPolicy              string
+	ExpandContentWarnings bool
+	ShowSensitiveMedia    bool
+	IsPrivate             bool
+}
+
+type UserBlock struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
 	ID             uuid.UUID
 	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	SubscriptionID uuid.UUID
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	DeliveredAt    sql.NullTime
+	EventID        uuid.NullUUID
+}
+
+type WebhookDeliveryAttempt struct {
+	ID          uuid.UUID
+	DeliveryID  uuid.UUID
+	AttemptedAt time.Time
+	StatusCode  sql.NullInt32
+	Error       sql.NullString
+	DurationMs  int32
+}
+
+type WebhookEvent struct {
+	ID          string
+	EventType   string
+	Payload     json.RawMessage
+	ReceivedAt  time.Time
+	ProcessedAt sql.NullTime
+	Outcome     string
+	Error       sql.NullString
+	Attempts    int32
+	ClaimedAt   time.Time
+}
+
+type WebhookSubscription struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	ClientID  uuid.NullUUID
+	Url       string
+	Secret    string
+	Events    []string
 }
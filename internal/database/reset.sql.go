@@ -10,7 +10,7 @@ import (
 )
 
 const resetChirps = `-- name: ResetChirps :exec
-TRUNCATE TABLE chirps
+TRUNCATE TABLE chirps CASCADE
 `
 
 func (q *Queries) ResetChirps(ctx context.Context) error {
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Store is Queries bound to the connection pool, which can also run queries
+// in a transaction.
+type Store struct {
+	*Queries
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		Queries: New(db),
+		db:      db,
+	}
+}
+
+// InTx runs fn with queries bound to a new transaction. The transaction is
+// committed if fn returns nil and rolled back otherwise.
+func (s *Store) InTx(ctx context.Context, fn func(*Queries) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(s.WithTx(tx)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -12,16 +12,15 @@ import (
 )
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
     gen_random_uuid (),
     NOW(),
     NOW(),
     $1,
-    $2,
-    DEFAULT
+    $2
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, role, dm_policy, expand_content_warnings, show_sensitive_media, is_private
 `
 
 type CreateUserParams struct {
@@ -38,13 +37,17 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.Role,
+		&i.DmPolicy,
+		&i.ExpandContentWarnings,
+		&i.ShowSensitiveMedia,
+		&i.IsPrivate,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, role, dm_policy, expand_content_warnings, show_sensitive_media, is_private FROM users
 WHERE email = $1
 `
 
@@ -57,7 +60,67 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.Role,
+		&i.DmPolicy,
+		&i.ExpandContentWarnings,
+		&i.ShowSensitiveMedia,
+		&i.IsPrivate,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, role, dm_policy, expand_content_warnings, show_sensitive_media, is_private FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Role,
+		&i.DmPolicy,
+		&i.ExpandContentWarnings,
+		&i.ShowSensitiveMedia,
+		&i.IsPrivate,
+	)
+	return i, err
+}
+
+const updateContentPreferences = `-- name: UpdateContentPreferences :one
+UPDATE users
+SET expand_content_warnings = $2,
+    show_sensitive_media = $3,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, role, dm_policy, expand_content_warnings, show_sensitive_media, is_private
+`
+
+type UpdateContentPreferencesParams struct {
+	ID                    uuid.UUID
+	ExpandContentWarnings bool
+	ShowSensitiveMedia    bool
+}
+
+func (q *Queries) UpdateContentPreferences(ctx context.Context, arg UpdateContentPreferencesParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateContentPreferences, arg.ID, arg.ExpandContentWarnings, arg.ShowSensitiveMedia)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Role,
+		&i.DmPolicy,
+		&i.ExpandContentWarnings,
+		&i.ShowSensitiveMedia,
+		&i.IsPrivate,
 	)
 	return i, err
 }
@@ -67,7 +130,7 @@ UPDATE users
 SET email = $2,
     hashed_password = $3
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, role, dm_policy, expand_content_warnings, show_sensitive_media, is_private
 `
 
 type UpdateCredentialsParams struct {
@@ -85,18 +148,61 @@ func (q *Queries) UpdateCredentials(ctx context.Context, arg UpdateCredentialsPa
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.Role,
+		&i.DmPolicy,
+		&i.ExpandContentWarnings,
+		&i.ShowSensitiveMedia,
+		&i.IsPrivate,
 	)
 	return i, err
 }
 
-const upgradeUser = `-- name: UpgradeUser :exec
+const updatePasswordHash = `-- name: UpdatePasswordHash :exec
 UPDATE users
-SET is_chirpy_red = true
+SET hashed_password = $2,
+    updated_at = NOW()
 WHERE id = $1
 `
 
-func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, upgradeUser, id)
+type UpdatePasswordHashParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdatePasswordHash(ctx context.Context, arg UpdatePasswordHashParams) error {
+	_, err := q.db.ExecContext(ctx, updatePasswordHash, arg.ID, arg.HashedPassword)
 	return err
 }
+
+const updatePrivacy = `-- name: UpdatePrivacy :one
+UPDATE users
+SET dm_policy = $2,
+    is_private = $3,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, role, dm_policy, expand_content_warnings, show_sensitive_media, is_private
+`
+
+type UpdatePrivacyParams struct {
+	ID        uuid.UUID
+	DmPolicy  string
+	IsPrivate bool
+}
+
+func (q *Queries) UpdatePrivacy(ctx context.Context, arg UpdatePrivacyParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updatePrivacy, arg.ID, arg.DmPolicy, arg.IsPrivate)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.Role,
+		&i.DmPolicy,
+		&i.ExpandContentWarnings,
+		&i.ShowSensitiveMedia,
+		&i.IsPrivate,
+	)
+	return i, err
+}
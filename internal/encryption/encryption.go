@@ -0,0 +1,72 @@
+// Package encryption encrypts data stored at rest with a server-side key.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// The first byte of every ciphertext is the format version, so the
+// algorithm or key can be changed later without losing old data.
+const versionAESGCM byte = 1
+
+var (
+	ErrInvalidKey        = errors.New("encryption key must be 32 bytes, base64 encoded")
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+// Cipher encrypts with AES-256-GCM. Additional data binds a ciphertext to
+// its context (for example the row it belongs to), decrypting it with
+// different additional data fails.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher takes a base64 encoded 256-bit key.
+func NewCipher(encodedKey string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead: aead}, nil
+}
+
+func (c *Cipher) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{versionAESGCM}, nonce...)
+	return c.aead.Seal(out, nonce, plaintext, additionalData), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(ciphertext) < 1+nonceSize || ciphertext[0] != versionAESGCM {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce, sealed := ciphertext[1:1+nonceSize], ciphertext[1+nonceSize:]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+
+	return plaintext, nil
+}
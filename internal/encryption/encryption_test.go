@@ -0,0 +1,76 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestCipher(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	plaintext := []byte("see you at the party 🎉")
+	aad := []byte("message-1")
+
+	ciphertext, err := c.Encrypt(plaintext, aad)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		got, err := c.Decrypt(ciphertext, aad)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("expected %q, got %q", plaintext, got)
+		}
+	})
+
+	t.Run("ciphertext doesn't contain the plaintext", func(t *testing.T) {
+		if bytes.Contains(ciphertext, plaintext) {
+			t.Fatalf("plaintext leaked into the ciphertext")
+		}
+	})
+
+	t.Run("nonces are random", func(t *testing.T) {
+		other, _ := c.Encrypt(plaintext, aad)
+		if bytes.Equal(ciphertext, other) {
+			t.Fatalf("encrypting twice should give different ciphertexts")
+		}
+	})
+
+	t.Run("wrong additional data", func(t *testing.T) {
+		if _, err := c.Decrypt(ciphertext, []byte("message-2")); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Fatalf("expected ErrInvalidCiphertext, got %v", err)
+		}
+	})
+
+	t.Run("tampered ciphertext", func(t *testing.T) {
+		tampered := bytes.Clone(ciphertext)
+		tampered[len(tampered)-1] ^= 1
+		if _, err := c.Decrypt(tampered, aad); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Fatalf("expected ErrInvalidCiphertext, got %v", err)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, _ := NewCipher(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32)))
+		if _, err := other.Decrypt(ciphertext, aad); !errors.Is(err, ErrInvalidCiphertext) {
+			t.Fatalf("expected ErrInvalidCiphertext, got %v", err)
+		}
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+			if _, err := NewCipher(key); !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("NewCipher(%q): expected ErrInvalidKey, got %v", key, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,295 @@
+// Package linkpreview fetches the preview card of a web page: its title,
+// description and image, read from the Open Graph and Twitter card tags or
+// the page's title.
+//
+// The pages are chosen by users, so the HTTP fetcher only connects to
+// public addresses. The check runs when connecting, after the host is
+// resolved, so a name that resolves to a private address is blocked too.
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	ErrBlockedAddress   = errors.New("address is not public")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrUnsupportedURL   = errors.New("only http and https URLs are supported")
+	ErrNotHTML          = errors.New("page is not HTML")
+	ErrNoPreview        = errors.New("page has no preview")
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+)
+
+type Preview struct {
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// Fetcher gets the preview of the page at a URL.
+type Fetcher interface {
+	Fetch(ctx context.Context, rawURL string) (Preview, error)
+}
+
+type Options struct {
+	Timeout      time.Duration
+	MaxBodySize  int64
+	MaxRedirects int
+	UserAgent    string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Timeout:      5 * time.Second,
+		MaxBodySize:  512 << 10,
+		MaxRedirects: 3,
+		UserAgent:    "ChirpyBot/1.0 (link previews)",
+	}
+}
+
+// HTTPFetcher fetches previews over HTTP.
+type HTTPFetcher struct {
+	client  *http.Client
+	options Options
+}
+
+// NewHTTPFetcher returns a fetcher that only connects to public addresses.
+func NewHTTPFetcher(options Options) *HTTPFetcher {
+	return newHTTPFetcher(options, IsPublic)
+}
+
+func newHTTPFetcher(options Options, allowed func(netip.Addr) bool) *HTTPFetcher {
+	dialer := &net.Dialer{
+		Timeout: options.Timeout,
+		// Called with the resolved address of every connection, redirects
+		// included
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !allowed(addr.Unmap()) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		// Never go through a proxy, it would connect on our behalf
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    options.Timeout,
+		ResponseHeaderTimeout:  options.Timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		DisableKeepAlives:      true,
+	}
+
+	return &HTTPFetcher{
+		options: options,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   options.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > options.MaxRedirects {
+					return ErrTooManyRedirects
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return ErrUnsupportedURL
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, rawURL string) (Preview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Preview{}, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Preview{}, ErrUnsupportedURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Preview{}, err
+	}
+	req.Header.Set("User-Agent", f.options.UserAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Preview{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return Preview{}, ErrNotHTML
+	}
+
+	// Whatever is after the limit is ignored, the tags are in the head
+	preview := parse(io.LimitReader(resp.Body, f.options.MaxBodySize), resp.Request.URL)
+	if preview.Title == "" {
+		return Preview{}, ErrNoPreview
+	}
+
+	return preview, nil
+}
+
+// parse reads the preview from the head of an HTML document. Open Graph
+// tags are preferred over Twitter card tags, which are preferred over the
+// plain title and description.
+func parse(r io.Reader, base *url.URL) Preview {
+	found := map[string]string{}
+	set := func(key, value string) {
+		value = strings.TrimSpace(value)
+		if _, ok := found[key]; !ok && value != "" {
+			found[key] = value
+		}
+	}
+
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+loop:
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			break loop
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = true
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = attr.Val
+					}
+				}
+				set(key, content)
+			case "body":
+				break loop
+			}
+		case html.TextToken:
+			if inTitle {
+				set("title", string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "title" {
+				inTitle = false
+			} else if string(name) == "head" {
+				break loop
+			}
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := found[key]; ok {
+				return value
+			}
+		}
+		return ""
+	}
+
+	return Preview{
+		Title:       truncate(first("og:title", "twitter:title", "title"), maxTitleLength),
+		Description: truncate(first("og:description", "twitter:description", "description"), maxDescriptionLength),
+		ImageURL:    resolveImage(base, first("og:image", "og:image:url", "twitter:image")),
+	}
+}
+
+func resolveImage(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	return u.String()
+}
+
+func truncate(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+// Special purpose and non-public ranges not covered by the netip methods
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// IsPublic reports whether addr is a public unicast address.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Permanent reports whether fetching the preview again can't succeed.
+func Permanent(err error) bool {
+	return errors.Is(err, ErrBlockedAddress) ||
+		errors.Is(err, ErrTooManyRedirects) ||
+		errors.Is(err, ErrUnsupportedURL) ||
+		errors.Is(err, ErrNotHTML) ||
+		errors.Is(err, ErrNoPreview)
+}
@@ -0,0 +1,179 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFetcher can reach the httptest servers, which listen on loopback
+func testFetcher(options Options) *HTTPFetcher {
+	return newHTTPFetcher(options, func(netip.Addr) bool { return true })
+}
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<!doctype html><html><head>
+			<title>Fallback title</title>
+			<meta property="og:title" content="  Chirpy   launches ">
+			<meta name="description" content="Plain description">
+			<meta property="og:image" content="/images/card.png">
+			</head><body><meta property="og:description" content="not in the head"></body></html>`)
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>Just a title</title></head></html>`)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"title": "nope"}`)
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body>no head</body></html>`)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head>"+strings.Repeat(" ", 4096)+"<title>Too far</title></head></html>")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	mux.HandleFunc("/redirect/{n}", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscan(r.PathValue("n"), &n)
+		if n == 0 {
+			http.Redirect(w, r, "/plain", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	options := DefaultOptions()
+	options.Timeout = 500 * time.Millisecond
+	options.MaxBodySize = 1024
+	fetcher := testFetcher(options)
+
+	t.Run("open graph tags", func(t *testing.T) {
+		preview, err := fetcher.Fetch(context.Background(), server.URL+"/article")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := Preview{
+			Title:       "Chirpy launches",
+			Description: "Plain description",
+			ImageURL:    server.URL + "/images/card.png",
+		}
+		if preview != want {
+			t.Fatalf("expected %+v, got %+v", want, preview)
+		}
+	})
+
+	t.Run("title only", func(t *testing.T) {
+		preview, err := fetcher.Fetch(context.Background(), server.URL+"/plain")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if preview.Title != "Just a title" || preview.Description != "" || preview.ImageURL != "" {
+			t.Fatalf("unexpected preview: %+v", preview)
+		}
+	})
+
+	tests := []struct {
+		name string
+		path string
+		want error
+	}{
+		{"not html", "/json", ErrNotHTML},
+		{"no title", "/empty", ErrNoPreview},
+		{"body over the size limit", "/huge", ErrNoPreview},
+		{"too many redirects", "/redirect/5", ErrTooManyRedirects},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := fetcher.Fetch(context.Background(), server.URL+tc.path); !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	t.Run("redirects within the limit", func(t *testing.T) {
+		if _, err := fetcher.Fetch(context.Background(), server.URL+"/redirect/1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		if _, err := fetcher.Fetch(context.Background(), server.URL+"/missing"); err == nil {
+			t.Fatalf("expected an error")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		start := time.Now()
+		if _, err := fetcher.Fetch(context.Background(), server.URL+"/slow"); err == nil {
+			t.Fatalf("expected an error")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected the fetch to time out, took %v", elapsed)
+		}
+	})
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		if _, err := fetcher.Fetch(context.Background(), "file:///etc/passwd"); !errors.Is(err, ErrUnsupportedURL) {
+			t.Fatalf("expected ErrUnsupportedURL, got %v", err)
+		}
+	})
+
+	t.Run("private addresses are blocked", func(t *testing.T) {
+		fetcher := NewHTTPFetcher(options)
+		if _, err := fetcher.Fetch(context.Background(), server.URL+"/plain"); !errors.Is(err, ErrBlockedAddress) {
+			t.Fatalf("expected ErrBlockedAddress, got %v", err)
+		}
+	})
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"::1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, tc := range tests {
+		if got := IsPublic(netip.MustParseAddr(tc.addr)); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.addr, tc.want, got)
+		}
+	}
+}
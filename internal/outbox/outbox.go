@@ -0,0 +1,72 @@
+// Package outbox dispatches domain events stored in the outbox table to
+// in-process subscribers. Events are written in the same transaction as the
+// change they describe and published afterwards, at least once: when a
+// subscriber fails the event is dispatched again to every subscriber, so
+// subscribers must be idempotent.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Event struct {
+	ID        uuid.UUID
+	Type      string
+	CreatedAt time.Time
+	Payload   json.RawMessage
+}
+
+type Handler func(ctx context.Context, event Event) error
+
+type subscriber struct {
+	name    string
+	handler Handler
+}
+
+// Dispatcher routes events to the subscribers of their type. Subscribe is
+// not safe to call once events are being dispatched.
+type Dispatcher struct {
+	subscribers map[string][]subscriber
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{subscribers: make(map[string][]subscriber)}
+}
+
+// Subscribe registers handler for eventType. name identifies the
+// subscriber in errors.
+func (d *Dispatcher) Subscribe(eventType, name string, handler Handler) {
+	d.subscribers[eventType] = append(d.subscribers[eventType], subscriber{name: name, handler: handler})
+}
+
+// Dispatch calls every subscriber of the event, even if some of them fail,
+// and returns their errors joined. Events nobody subscribed to are dropped.
+func (d *Dispatcher) Dispatch(ctx context.Context, event Event) error {
+	var errs []error
+	for _, sub := range d.subscribers[event.Type] {
+		if err := sub.handler(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sub.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Backoff returns how long to wait before dispatching an event again after
+// attempts failed dispatches.
+func Backoff(attempts int) time.Duration {
+	const base, max = time.Second, time.Hour
+
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+
+	return min(delay, max)
+}
@@ -0,0 +1,60 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDispatcher(t *testing.T) {
+	dispatcher := NewDispatcher()
+	var calls []string
+
+	dispatcher.Subscribe("chirp.created", "first", func(ctx context.Context, event Event) error {
+		calls = append(calls, "first")
+		return errors.New("boom")
+	})
+	dispatcher.Subscribe("chirp.created", "second", func(ctx context.Context, event Event) error {
+		calls = append(calls, "second")
+		return nil
+	})
+	dispatcher.Subscribe("chirp.deleted", "other", func(ctx context.Context, event Event) error {
+		calls = append(calls, "other")
+		return nil
+	})
+
+	t.Run("failing subscriber doesn't stop the others", func(t *testing.T) {
+		err := dispatcher.Dispatch(context.Background(), Event{ID: uuid.New(), Type: "chirp.created"})
+		if err == nil || !strings.Contains(err.Error(), "first: boom") {
+			t.Fatalf("expected the first subscriber's error, got %v", err)
+		}
+		if strings.Join(calls, ",") != "first,second" {
+			t.Fatalf("expected both subscribers to be called, got %v", calls)
+		}
+	})
+
+	t.Run("events without subscribers are dropped", func(t *testing.T) {
+		if err := dispatcher.Dispatch(context.Background(), Event{ID: uuid.New(), Type: "user.created"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		5:  16 * time.Second,
+		20: time.Hour,
+	}
+
+	for attempts, want := range tests {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
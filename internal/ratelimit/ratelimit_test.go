@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := New()
+	limiter.now = func() time.Time { return now }
+
+	t.Run("burst up to the limit", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if ok, _ := limiter.Allow("alice", 3); !ok {
+				t.Fatalf("request %d should be allowed", i+1)
+			}
+		}
+
+		ok, wait := limiter.Allow("alice", 3)
+		if ok {
+			t.Fatalf("fourth request should be limited")
+		}
+		if wait != 20*time.Second {
+			t.Fatalf("expected to wait 20s for the next token, got %v", wait)
+		}
+	})
+
+	t.Run("keys are independent", func(t *testing.T) {
+		if ok, _ := limiter.Allow("bob", 3); !ok {
+			t.Fatalf("other keys shouldn't be limited")
+		}
+	})
+
+	t.Run("tokens refill over time", func(t *testing.T) {
+		now = now.Add(20 * time.Second)
+		if ok, _ := limiter.Allow("alice", 3); !ok {
+			t.Fatalf("request should be allowed after refill")
+		}
+		if ok, _ := limiter.Allow("alice", 3); ok {
+			t.Fatalf("only one token should have been refilled")
+		}
+	})
+
+	t.Run("higher limit refills faster", func(t *testing.T) {
+		now = now.Add(time.Second)
+		if ok, _ := limiter.Allow("alice", 3); ok {
+			t.Fatalf("a second isn't enough to refill at 3 per minute")
+		}
+		if ok, _ := limiter.Allow("alice", 120); ok {
+			t.Fatalf("tokens shouldn't be granted retroactively")
+		}
+		now = now.Add(time.Second)
+		if ok, _ := limiter.Allow("alice", 120); !ok {
+			t.Fatalf("request should be allowed with the higher limit")
+		}
+	})
+
+	t.Run("prune idle buckets", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		limiter.Prune(time.Minute)
+		if len(limiter.buckets) != 0 {
+			t.Fatalf("expected idle buckets to be pruned, got %d", len(limiter.buckets))
+		}
+	})
+}
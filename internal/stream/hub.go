@@ -0,0 +1,86 @@
+// Package stream fans events out to the clients connected to this instance.
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Event is an outbox event. Seq orders events and is used to resume a
+// stream.
+type Event struct {
+	Seq     int64
+	Type    string
+	Payload json.RawMessage
+}
+
+// Hub delivers every published event to the subscriptions whose filter
+// accepts it.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+type Subscription struct {
+	hub    *Hub
+	filter func(Event) bool
+	events chan Event
+	once   sync.Once
+}
+
+// Subscribe returns a subscription to the events filter accepts. Up to
+// buffer events are queued for the subscriber, a subscriber that falls
+// further behind is dropped and its channel closed.
+func (h *Hub) Subscribe(filter func(Event) bool, buffer int) *Subscription {
+	sub := &Subscription{
+		hub:    h,
+		filter: filter,
+		events: make(chan Event, buffer),
+	}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.close()
+}
+
+// close must be called with the hub lock held.
+func (s *Subscription) close() {
+	s.once.Do(func() {
+		delete(s.hub.subs, s)
+		close(s.events)
+	})
+}
+
+// Publish never blocks on slow subscribers.
+func (h *Hub) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			sub.close()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package stream
+
+import "testing"
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+
+	all := hub.Subscribe(nil, 10)
+	onlyChirps := hub.Subscribe(func(e Event) bool { return e.Type == "chirp.created" }, 10)
+	slow := hub.Subscribe(nil, 1)
+
+	hub.Publish(Event{Seq: 1, Type: "chirp.created"})
+	hub.Publish(Event{Seq: 2, Type: "notification.created"})
+
+	t.Run("events are delivered in order", func(t *testing.T) {
+		if e := <-all.Events(); e.Seq != 1 {
+			t.Fatalf("expected event 1, got %d", e.Seq)
+		}
+		if e := <-all.Events(); e.Seq != 2 {
+			t.Fatalf("expected event 2, got %d", e.Seq)
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		if e := <-onlyChirps.Events(); e.Seq != 1 {
+			t.Fatalf("expected event 1, got %d", e.Seq)
+		}
+		if len(onlyChirps.Events()) != 0 {
+			t.Fatalf("filtered events shouldn't be delivered")
+		}
+	})
+
+	t.Run("slow subscribers are dropped", func(t *testing.T) {
+		if e := <-slow.Events(); e.Seq != 1 {
+			t.Fatalf("expected event 1, got %d", e.Seq)
+		}
+		if _, ok := <-slow.Events(); ok {
+			t.Fatalf("expected the slow subscription to be closed")
+		}
+	})
+
+	t.Run("closed subscriptions don't receive events", func(t *testing.T) {
+		all.Close()
+		all.Close()
+		hub.Publish(Event{Seq: 3, Type: "chirp.created"})
+		if _, ok := <-all.Events(); ok {
+			t.Fatalf("expected the subscription to be closed")
+		}
+		if e := <-onlyChirps.Events(); e.Seq != 3 {
+			t.Fatalf("expected event 3, got %d", e.Seq)
+		}
+	})
+}
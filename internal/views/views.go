@@ -0,0 +1,109 @@
+// Package views collects chirp impressions and detail views in memory, so
+// counting them doesn't add a write to the requests that show chirps. The
+// collected views are drained periodically and stored in bulk.
+//
+// A viewer is counted once per chirp, kind and window. Views are deduplicated
+// here for the views seen by one instance, and again when stored for the
+// views seen by all of them.
+package views
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Kind string
+
+const (
+	// Impression is a chirp shown in a listing
+	Impression Kind = "impression"
+	// Detail is a chirp opened on its own
+	Detail Kind = "view"
+)
+
+// Window is how long a viewer is counted once for.
+const Window = time.Hour
+
+type View struct {
+	ChirpID uuid.UUID
+	Kind    Kind
+	// Viewer identifies the viewer without revealing who it is
+	Viewer string
+	// Window is the start of the window the view is in
+	Window time.Time
+}
+
+// Recorder holds the views until they are drained. It keeps at most
+// maxPending views, when it is full new views are dropped: counts are
+// approximate rather than slowing down requests or using unbounded memory.
+type Recorder struct {
+	mu         sync.Mutex
+	pending    map[View]struct{}
+	maxPending int
+	dropped    int
+	now        func() time.Time
+}
+
+func NewRecorder(maxPending int) *Recorder {
+	return &Recorder{
+		pending:    make(map[View]struct{}),
+		maxPending: maxPending,
+		now:        time.Now,
+	}
+}
+
+// Record counts a view of each chirp by viewer.
+func (r *Recorder) Record(kind Kind, viewer string, chirpIDs ...uuid.UUID) {
+	window := r.now().UTC().Truncate(Window)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, chirpID := range chirpIDs {
+		view := View{ChirpID: chirpID, Kind: kind, Viewer: viewer, Window: window}
+		if _, ok := r.pending[view]; ok {
+			continue
+		}
+		if len(r.pending) >= r.maxPending {
+			r.dropped++
+			continue
+		}
+		r.pending[view] = struct{}{}
+	}
+}
+
+// Restore puts back views that were drained but couldn't be stored, so they
+// are stored with the next ones. Like recorded views, they are dropped when
+// the recorder is full.
+func (r *Recorder) Restore(views []View) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, view := range views {
+		if _, ok := r.pending[view]; ok {
+			continue
+		}
+		if len(r.pending) >= r.maxPending {
+			r.dropped++
+			continue
+		}
+		r.pending[view] = struct{}{}
+	}
+}
+
+// Drain returns the views recorded since the last call and how many were
+// dropped.
+func (r *Recorder) Drain() ([]View, int) {
+	r.mu.Lock()
+	pending, dropped := r.pending, r.dropped
+	r.pending, r.dropped = make(map[View]struct{}), 0
+	r.mu.Unlock()
+
+	views := make([]View, 0, len(pending))
+	for view := range pending {
+		views = append(views, view)
+	}
+	return views, dropped
+}
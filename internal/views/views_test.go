@@ -0,0 +1,81 @@
+package views
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRecorder(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	r := NewRecorder(10)
+	r.now = func() time.Time { return now }
+
+	chirp1, chirp2 := uuid.New(), uuid.New()
+
+	r.Record(Impression, "alice", chirp1, chirp2)
+	r.Record(Impression, "alice", chirp1)
+	r.Record(Detail, "alice", chirp1)
+	r.Record(Impression, "bob", chirp1)
+
+	// Same viewer in the next window
+	now = now.Add(time.Hour)
+	r.Record(Impression, "alice", chirp1)
+
+	views, dropped := r.Drain()
+	if dropped != 0 {
+		t.Fatalf("expected no views dropped, got %d", dropped)
+	}
+	if len(views) != 5 {
+		t.Fatalf("expected 5 views, got %d: %+v", len(views), views)
+	}
+
+	windows := map[time.Time]int{}
+	for _, view := range views {
+		windows[view.Window]++
+	}
+	if windows[time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)] != 4 || windows[time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)] != 1 {
+		t.Fatalf("unexpected windows: %v", windows)
+	}
+
+	if views, _ := r.Drain(); len(views) != 0 {
+		t.Fatalf("expected the recorder to be empty after draining, got %d views", len(views))
+	}
+}
+
+func TestRecorderDropsWhenFull(t *testing.T) {
+	r := NewRecorder(2)
+
+	r.Record(Impression, "alice", uuid.New(), uuid.New(), uuid.New())
+	r.Record(Detail, "bob", uuid.New())
+
+	views, dropped := r.Drain()
+	if len(views) != 2 || dropped != 2 {
+		t.Fatalf("expected 2 views and 2 dropped, got %d and %d", len(views), dropped)
+	}
+
+	// Draining makes room again
+	r.Record(Detail, "bob", uuid.New())
+	if views, _ := r.Drain(); len(views) != 1 {
+		t.Fatalf("expected 1 view, got %d", len(views))
+	}
+}
+
+func TestRecorderRestore(t *testing.T) {
+	r := NewRecorder(3)
+	chirp := uuid.New()
+
+	r.Record(Impression, "alice", chirp)
+	views, _ := r.Drain()
+
+	// Recorded again while the drained views were being stored
+	r.Record(Impression, "alice", chirp)
+	r.Record(Impression, "bob", chirp, uuid.New())
+
+	r.Restore(append(views, View{ChirpID: uuid.New(), Kind: Detail, Viewer: "carol"}))
+	views, dropped := r.Drain()
+	if len(views) != 3 || dropped != 1 {
+		t.Fatalf("expected 3 views and 1 dropped, got %d and %d", len(views), dropped)
+	}
+}
@@ -0,0 +1,212 @@
+// Package webhooks sends signed event notifications to the endpoints that
+// users and apps subscribe.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/linkpreview"
+)
+
+// Subscribable events have the same names as the domain events they are
+// sent for.
+const (
+	EventChirpCreated  = "chirp.created"
+	EventChirpDeleted  = "chirp.deleted"
+	EventUserFollowed  = "user.followed"
+	EventUserMentioned = "user.mentioned"
+)
+
+// Events lists the events that can be subscribed to.
+var Events = []string{
+	EventChirpCreated,
+	EventChirpDeleted,
+	EventUserFollowed,
+	EventUserMentioned,
+}
+
+func IsEvent(name string) bool {
+	return slices.Contains(Events, name)
+}
+
+// Deliveries are signed the same way Polka signs the webhooks it sends us,
+// see auth.WebhookVerifier.
+const (
+	TimestampHeader = "X-Chirpy-Timestamp"
+	SignatureHeader = "X-Chirpy-Signature"
+	EventHeader     = "X-Chirpy-Event"
+	DeliveryHeader  = "X-Chirpy-Delivery"
+)
+
+const secretPrefix = "whsec_"
+
+// NewSecret returns a signing secret for a subscription.
+func NewSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return secretPrefix + hex.EncodeToString(key), nil
+}
+
+type payload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// NewPayload builds the body sent for an event.
+func NewPayload(event string, data any, now time.Time) ([]byte, error) {
+	return json.Marshal(payload{
+		Event:     event,
+		CreatedAt: now,
+		Data:      data,
+	})
+}
+
+// RetryPolicy decides when failed deliveries are retried. The delay doubles
+// after every failed attempt, and deliveries that still fail after
+// MaxAttempts are dead-lettered.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 8,
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    6 * time.Hour,
+	}
+}
+
+// Backoff returns how long to wait before the next attempt after attempts
+// failed ones, or false if the delivery should be given up.
+func (p RetryPolicy) Backoff(attempts int) (time.Duration, bool) {
+	if attempts >= p.MaxAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay), true
+}
+
+type Sender struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewSender returns a sender that only connects to public addresses, the
+// endpoints are chosen by users. Like link previews, the check runs on the
+// resolved address of every connection.
+func NewSender(timeout time.Duration) *Sender {
+	return newSender(timeout, linkpreview.IsPublic)
+}
+
+func newSender(timeout time.Duration, allowed func(netip.Addr) bool) *Sender {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !allowed(addr.Unmap()) {
+				return linkpreview.ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	return &Sender{
+		client: &http.Client{
+			Transport: &http.Transport{
+				// Never go through a proxy, it would connect on our behalf
+				Proxy:               nil,
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+			},
+			Timeout: timeout,
+			// A redirect could send the payload somewhere the subscriber
+			// didn't ask for
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		now: time.Now,
+	}
+}
+
+type Delivery struct {
+	ID      string
+	Event   string
+	URL     string
+	Secret  string
+	Payload []byte
+}
+
+// Result is the outcome of a single delivery attempt. StatusCode is 0 when
+// no response was received.
+type Result struct {
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+}
+
+func (r Result) OK() bool {
+	return r.Err == nil
+}
+
+// Send makes one delivery attempt. Any response other than 2xx is an error.
+func (s *Sender) Send(ctx context.Context, delivery Delivery) Result {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return Result{Err: err}
+	}
+
+	now := s.now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, auth.SignWebhook(delivery.Secret, now, delivery.Payload))
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	result := Result{Duration: time.Since(start)}
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		result.Err = fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+
+	return result
+}
@@ -0,0 +1,124 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/linkpreview"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: 5 * time.Minute}
+
+	tests := []struct {
+		attempts  int
+		wantDelay time.Duration
+		wantRetry bool
+	}{
+		{attempts: 1, wantDelay: time.Minute, wantRetry: true},
+		{attempts: 2, wantDelay: 2 * time.Minute, wantRetry: true},
+		{attempts: 3, wantDelay: 4 * time.Minute, wantRetry: true},
+		{attempts: 4, wantDelay: 5 * time.Minute, wantRetry: true},
+		{attempts: 5, wantRetry: false},
+	}
+
+	for _, tt := range tests {
+		delay, retry := policy.Backoff(tt.attempts)
+		if retry != tt.wantRetry || delay != tt.wantDelay {
+			t.Errorf("Backoff(%d) = %v, %v, want %v, %v", tt.attempts, delay, retry, tt.wantDelay, tt.wantRetry)
+		}
+	}
+}
+
+func TestSender(t *testing.T) {
+	const secret = "whsec_test"
+	status := http.StatusNoContent
+	var received *http.Request
+	var receivedBody []byte
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	body, err := NewPayload(EventChirpCreated, map[string]string{"body": "hello"}, time.Now())
+	if err != nil {
+		t.Fatalf("couldn't build payload: %v", err)
+	}
+	delivery := Delivery{
+		ID:      "delivery-1",
+		Event:   EventChirpCreated,
+		URL:     receiver.URL,
+		Secret:  secret,
+		Payload: body,
+	}
+	// The receiver listens on a loopback address
+	sender := newSender(time.Second, func(netip.Addr) bool { return true })
+
+	t.Run("signed delivery", func(t *testing.T) {
+		result := sender.Send(context.Background(), delivery)
+		if !result.OK() || result.StatusCode != http.StatusNoContent {
+			t.Fatalf("expected a successful delivery, got %+v", result)
+		}
+
+		if received.Header.Get(EventHeader) != EventChirpCreated || received.Header.Get(DeliveryHeader) != "delivery-1" {
+			t.Fatalf("missing event headers: %v", received.Header)
+		}
+		if string(receivedBody) != string(body) {
+			t.Fatalf("expected body %s, got %s", body, receivedBody)
+		}
+
+		seconds, err := strconv.ParseInt(received.Header.Get(TimestampHeader), 10, 64)
+		if err != nil {
+			t.Fatalf("invalid timestamp header: %v", err)
+		}
+		want := auth.SignWebhook(secret, time.Unix(seconds, 0), receivedBody)
+		if received.Header.Get(SignatureHeader) != want {
+			t.Fatalf("expected signature %s, got %s", want, received.Header.Get(SignatureHeader))
+		}
+	})
+
+	t.Run("error status fails the attempt", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		result := sender.Send(context.Background(), delivery)
+		if result.OK() || result.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected a failed delivery with the response status, got %+v", result)
+		}
+	})
+
+	t.Run("redirects aren't followed", func(t *testing.T) {
+		status = http.StatusFound
+		result := sender.Send(context.Background(), delivery)
+		if result.OK() || result.StatusCode != http.StatusFound {
+			t.Fatalf("expected the redirect to fail the delivery, got %+v", result)
+		}
+	})
+
+	t.Run("private addresses are blocked", func(t *testing.T) {
+		status = http.StatusNoContent
+		result := NewSender(time.Second).Send(context.Background(), delivery)
+		if result.OK() || !errors.Is(result.Err, linkpreview.ErrBlockedAddress) {
+			t.Fatalf("expected ErrBlockedAddress, got %+v", result)
+		}
+	})
+
+	t.Run("unreachable endpoint", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+
+		result := sender.Send(context.Background(), Delivery{URL: closed.URL, Secret: secret, Payload: body})
+		if result.OK() || result.StatusCode != 0 {
+			t.Fatalf("expected a failed delivery without status, got %+v", result)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/miguelsoffarelli/chirpy/internal/chirptext"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/linkpreview"
+	"github.com/miguelsoffarelli/chirpy/internal/outbox"
+)
+
+const (
+	linkPreviewPending = "pending"
+	linkPreviewReady   = "ready"
+	linkPreviewFailed  = "failed"
+)
+
+const (
+	linkPreviewBatchSize   = 10
+	linkPreviewLease       = 2 * time.Minute
+	linkPreviewMaxAttempts = 3
+	linkPreviewRetryDelay  = 5 * time.Minute
+)
+
+// ChirpURL is a link in a chirp. Start and End are offsets in code points.
+// The preview is only there once it has been fetched.
+type ChirpURL struct {
+	URL     string       `json:"url"`
+	Start   int          `json:"start"`
+	End     int          `json:"end"`
+	Preview *LinkPreview `json:"preview"`
+}
+
+type LinkPreview struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+func chirpURLs(body string) []ChirpURL {
+	urls := make([]ChirpURL, 0)
+	for _, u := range chirptext.URLs(body) {
+		urls = append(urls, ChirpURL{URL: u.URL, Start: u.Start, End: u.End})
+	}
+
+	return urls
+}
+
+// queueLinkPreviews queues a preview fetch for the links of new and edited
+// chirps. Links that were already fetched use the cached preview.
+func (cfg *apiConfig) queueLinkPreviews(ctx context.Context, event outbox.Event) error {
+	var chirp Chirp
+	if err := json.Unmarshal(event.Payload, &chirp); err != nil {
+		return err
+	}
+
+	urls := make([]string, 0, len(chirp.URLs))
+	for _, u := range chirp.URLs {
+		urls = append(urls, u.URL)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	return cfg.DB.QueueLinkPreviews(ctx, urls)
+}
+
+// fetchLinkPreviews fetches the previews that are due.
+func (cfg *apiConfig) fetchLinkPreviews(ctx context.Context) error {
+	previews, err := cfg.DB.ClaimDueLinkPreviews(ctx, database.ClaimDueLinkPreviewsParams{
+		LeaseUntil: time.Now().UTC().Add(linkPreviewLease),
+		BatchSize:  linkPreviewBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, preview := range previews {
+		if err := cfg.fetchLinkPreview(ctx, preview); err != nil {
+			log.Printf("couldn't record link preview of %s: %v", preview.Url, err)
+		}
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) fetchLinkPreview(ctx context.Context, preview database.LinkPreview) error {
+	fetched, fetchErr := cfg.LinkPreviewFetcher.Fetch(ctx, preview.Url)
+
+	now := time.Now().UTC()
+	finish := database.FinishLinkPreviewParams{
+		Url:           preview.Url,
+		Status:        linkPreviewReady,
+		Title:         fetched.Title,
+		Description:   fetched.Description,
+		ImageUrl:      fetched.ImageURL,
+		NextAttemptAt: now,
+		FetchedAt:     sql.NullTime{Time: now, Valid: true},
+	}
+	if fetchErr != nil {
+		finish.Error = sql.NullString{String: fetchErr.Error(), Valid: true}
+		finish.FetchedAt = sql.NullTime{}
+		if linkpreview.Permanent(fetchErr) || preview.Attempts+1 >= linkPreviewMaxAttempts {
+			finish.Status = linkPreviewFailed
+		} else {
+			finish.Status = linkPreviewPending
+			finish.NextAttemptAt = now.Add(time.Duration(preview.Attempts+1) * linkPreviewRetryDelay)
+		}
+	}
+
+	return cfg.DB.FinishLinkPreview(ctx, finish)
+}
+
+func (cfg *apiConfig) mapChirpWithPreviews(ctx context.Context, chirp database.Chirp) Chirp {
+	response := []Chirp{mapChirp(chirp)}
+	cfg.attachLinkPreviews(ctx, response)
+	return response[0]
+}
+
+// attachLinkPreviews adds the cached previews to the links of chirps.
+// Previews are optional, chirps are returned without them if they can't be
+// loaded.
+func (cfg *apiConfig) attachLinkPreviews(ctx context.Context, chirps []Chirp) {
+	var urls []string
+	for _, chirp := range chirps {
+		for _, u := range chirp.URLs {
+			urls = append(urls, u.URL)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	previews, err := cfg.DB.GetLinkPreviews(ctx, urls)
+	if err != nil {
+		log.Printf("couldn't load link previews: %v", err)
+		return
+	}
+
+	byURL := make(map[string]*LinkPreview, len(previews))
+	for _, preview := range previews {
+		byURL[preview.Url] = &LinkPreview{
+			Title:       preview.Title,
+			Description: preview.Description,
+			ImageURL:    preview.ImageUrl,
+		}
+	}
+
+	for i := range chirps {
+		for j := range chirps[i].URLs {
+			chirps[i].URLs[j].Preview = byURL[chirps[i].URLs[j].URL]
+		}
+	}
+}
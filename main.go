@@ -1,23 +1,52 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+	"github.com/miguelsoffarelli/chirpy/internal/contentfilter"
 	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/encryption"
+	"github.com/miguelsoffarelli/chirpy/internal/linkpreview"
+	"github.com/miguelsoffarelli/chirpy/internal/outbox"
+	"github.com/miguelsoffarelli/chirpy/internal/ratelimit"
+	"github.com/miguelsoffarelli/chirpy/internal/stream"
+	"github.com/miguelsoffarelli/chirpy/internal/views"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	DB             *database.Queries
-	PLATFORM       string
-	SECRET         string
-	POLKA_KEY      string
+	fileserverHits       atomic.Int32
+	websocketConnections atomic.Int32
+	streamConnections    atomic.Int32
+	streamPublishedSeq   atomic.Int64
+	DB                   *database.Store
+	PLATFORM             string
+	SECRET               string
+	TokenPolicy          auth.TokenPolicy
+	AccountThrottle      auth.ThrottlePolicy
+	IPThrottle           auth.ThrottlePolicy
+	PasswordPolicy       auth.PasswordPolicy
+	PolkaVerifier        auth.WebhookVerifier
+	RateLimiter          *ratelimit.Limiter
+	WebhookSender        *webhooks.Sender
+	WebhookRetry         webhooks.RetryPolicy
+	Events               *outbox.Dispatcher
+	Stream               *stream.Hub
+	MessageCipher        *encryption.Cipher
+	ContentFilter        *contentfilter.Pipeline
+	LinkPreviewFetcher   linkpreview.Fetcher
+	Views                *views.Recorder
 }
 
 func main() {
@@ -32,45 +61,186 @@ func main() {
 		log.Fatal(err)
 	}
 
-	dbQueries := database.New(db)
+	dbQueries := database.NewStore(db)
 	platform := os.Getenv("PLATFORM")
 	secret := os.Getenv("SECRET")
-	polkaApiKey := os.Getenv("POLKA_KEY")
+	// Comma separated, several secrets can be active while rotating them.
+	// POLKA_KEY is still accepted as a single secret.
+	polkaSecrets := os.Getenv("POLKA_WEBHOOK_SECRETS")
+	if polkaSecrets == "" {
+		polkaSecrets = os.Getenv("POLKA_KEY")
+	}
+	polkaVerifier := auth.NewWebhookVerifier(strings.Split(polkaSecrets, ","), 5*time.Minute)
+
+	// Direct messages are encrypted with this key, it can't be changed
+	// without losing the existing messages
+	messageCipher, err := encryption.NewCipher(os.Getenv("DM_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Fatalf("invalid DM_ENCRYPTION_KEY: %v", err)
+	}
+
+	tokenPolicy, err := auth.LoadTokenPolicy(os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passwordPolicy := auth.DefaultPasswordPolicy()
+	if minLength := os.Getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		passwordPolicy.MinLength, err = strconv.Atoi(minLength)
+		if err != nil {
+			log.Fatalf("invalid PASSWORD_MIN_LENGTH: %v", err)
+		}
+	}
+	if breachedList := os.Getenv("BREACHED_PASSWORDS_FILE"); breachedList != "" {
+		if err := passwordPolicy.LoadBreachedPasswords(breachedList); err != nil {
+			log.Fatalf("couldn't load breached passwords list: %v", err)
+		}
+	}
 
 	const filepathRoot = "."
 	const port = "8080"
 
 	apiCfg := apiConfig{
-		fileserverHits: atomic.Int32{},
-		DB:             dbQueries,
-		PLATFORM:       platform,
-		SECRET:         secret,
-		POLKA_KEY:      polkaApiKey,
+		fileserverHits:     atomic.Int32{},
+		DB:                 dbQueries,
+		PLATFORM:           platform,
+		SECRET:             secret,
+		TokenPolicy:        tokenPolicy,
+		AccountThrottle:    auth.DefaultAccountThrottlePolicy(),
+		IPThrottle:         auth.DefaultIPThrottlePolicy(),
+		PasswordPolicy:     passwordPolicy,
+		PolkaVerifier:      polkaVerifier,
+		RateLimiter:        ratelimit.New(),
+		WebhookSender:      webhooks.NewSender(10 * time.Second),
+		WebhookRetry:       webhooks.DefaultRetryPolicy(),
+		Events:             outbox.NewDispatcher(),
+		Stream:             stream.NewHub(),
+		MessageCipher:      messageCipher,
+		ContentFilter:      contentfilter.NewPipeline(nil),
+		LinkPreviewFetcher: linkpreview.NewHTTPFetcher(linkpreview.DefaultOptions()),
+		Views:              views.NewRecorder(maxPendingViews),
+	}
+	apiCfg.registerEventSubscribers()
+	if err := apiCfg.reloadContentFilter(context.Background()); err != nil {
+		log.Printf("Couldn't load content filter rules: %v", err)
 	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/app/", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))))
 
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirp)
-	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handleGetChirp)
+	mux.HandleFunc("POST /api/chirps", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.middlewareEntitlements(apiCfg.handlerCreateChirp)))
+	mux.HandleFunc("GET /api/chirps", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, apiCfg.handlerGetChirps))
+	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, apiCfg.handleGetChirp))
+	mux.HandleFunc("GET /api/stream", apiCfg.middlewareAuth(auth.ScopeChirpsRead, apiCfg.handlerStream))
+	mux.HandleFunc("GET /api/ws", apiCfg.handlerWebSocket)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerUsers)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerCredentials)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
+	mux.HandleFunc("PUT /api/users", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerCredentials))
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.middlewareEntitlements(apiCfg.handlerUpdateChirp)))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.middlewareEntitlements(apiCfg.handlerDeleteChirp)))
+	mux.HandleFunc("GET /api/chirps/{chirpID}/stats", apiCfg.middlewareAuth(auth.ScopeChirpsRead, apiCfg.handlerChirpStats))
+	mux.HandleFunc("GET /api/users/me/analytics", apiCfg.middlewareAuth(auth.ScopeChirpsRead, apiCfg.handlerAuthorAnalytics))
+	mux.HandleFunc("GET /api/chirps/trash", apiCfg.middlewareAuth(auth.ScopeChirpsRead, apiCfg.handlerListTrash))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/restore", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.handlerRestoreChirp))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/pin", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.handlerPinChirp))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/pin", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.handlerUnpinChirp))
+	mux.HandleFunc("POST /api/chirps/{chirpID}/like", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.handlerLikeChirp))
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.handlerUnlikeChirp))
+	mux.HandleFunc("POST /api/lists", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerCreateList))
+	mux.HandleFunc("GET /api/lists", apiCfg.middlewareAuth(auth.ScopeProfileRead, apiCfg.handlerListLists))
+	mux.HandleFunc("GET /api/lists/{listID}", apiCfg.middlewareOptionalAuth(auth.ScopeProfileRead, apiCfg.handlerGetList))
+	mux.HandleFunc("PUT /api/lists/{listID}", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerUpdateList))
+	mux.HandleFunc("DELETE /api/lists/{listID}", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerDeleteList))
+	mux.HandleFunc("GET /api/lists/{listID}/members", apiCfg.middlewareOptionalAuth(auth.ScopeProfileRead, apiCfg.handlerListListMembers))
+	mux.HandleFunc("POST /api/lists/{listID}/members", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerAddListMember))
+	mux.HandleFunc("DELETE /api/lists/{listID}/members/{userID}", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerRemoveListMember))
+	mux.HandleFunc("POST /api/lists/{listID}/subscription", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerSubscribeToList))
+	mux.HandleFunc("DELETE /api/lists/{listID}/subscription", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerUnsubscribeFromList))
+	mux.HandleFunc("GET /api/lists/{listID}/chirps", apiCfg.middlewareOptionalAuth(auth.ScopeChirpsRead, apiCfg.handlerGetListChirps))
+	mux.HandleFunc("GET /api/bookmarks", apiCfg.middlewareAuth(auth.ScopeChirpsRead, apiCfg.handlerListBookmarks))
+	mux.HandleFunc("POST /api/bookmarks", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.handlerAddBookmark))
+	mux.HandleFunc("DELETE /api/bookmarks/{chirpID}", apiCfg.middlewareAuth(auth.ScopeChirpsWrite, apiCfg.handlerDeleteBookmark))
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerChirpyRed)
+	mux.HandleFunc("POST /api/tokens", apiCfg.middlewareAuth(auth.ScopeTokensManage, apiCfg.handlerCreatePersonalToken))
+	mux.HandleFunc("GET /api/tokens", apiCfg.middlewareAuth(auth.ScopeTokensManage, apiCfg.handlerListPersonalTokens))
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", apiCfg.middlewareAuth(auth.ScopeTokensManage, apiCfg.handlerRevokePersonalToken))
+	mux.HandleFunc("PUT /api/users/me/privacy", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerUpdatePrivacy))
+	mux.HandleFunc("GET /api/users/me/content-preferences", apiCfg.middlewareAuth(auth.ScopeProfileRead, apiCfg.handlerGetContentPreferences))
+	mux.HandleFunc("PUT /api/users/me/content-preferences", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerUpdateContentPreferences))
+	mux.HandleFunc("GET /api/users/me/follow-requests", apiCfg.middlewareAuth(auth.ScopeProfileRead, apiCfg.handlerListFollowRequests))
+	mux.HandleFunc("POST /api/users/me/follow-requests/{userID}/approve", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerApproveFollowRequest))
+	mux.HandleFunc("DELETE /api/users/me/follow-requests/{userID}", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerRejectFollowRequest))
+	mux.HandleFunc("POST /api/users/{userID}/follow", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerFollowUser))
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerUnfollowUser))
+	mux.HandleFunc("POST /api/users/{userID}/block", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerBlockUser))
+	mux.HandleFunc("DELETE /api/users/{userID}/block", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerUnblockUser))
+	mux.HandleFunc("POST /api/conversations", apiCfg.middlewareAuth(auth.ScopeMessagesWrite, apiCfg.handlerCreateConversation))
+	mux.HandleFunc("GET /api/conversations", apiCfg.middlewareAuth(auth.ScopeMessagesRead, apiCfg.handlerListConversations))
+	mux.HandleFunc("GET /api/conversations/{conversationID}/messages", apiCfg.middlewareAuth(auth.ScopeMessagesRead, apiCfg.handlerListMessages))
+	mux.HandleFunc("POST /api/conversations/{conversationID}/messages", apiCfg.middlewareAuth(auth.ScopeMessagesWrite, apiCfg.handlerSendMessage))
+	mux.HandleFunc("POST /api/conversations/{conversationID}/read", apiCfg.middlewareAuth(auth.ScopeMessagesRead, apiCfg.handlerMarkConversationRead))
+	mux.HandleFunc("GET /api/notifications", apiCfg.middlewareAuth(auth.ScopeProfileRead, apiCfg.handlerListNotifications))
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerMarkAllNotificationsRead))
+	mux.HandleFunc("POST /api/notifications/{notificationID}/read", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerMarkNotificationRead))
+	mux.HandleFunc("GET /api/notifications/preferences", apiCfg.middlewareAuth(auth.ScopeProfileRead, apiCfg.handlerGetNotificationPreferences))
+	mux.HandleFunc("PUT /api/notifications/preferences", apiCfg.middlewareAuth(auth.ScopeProfileWrite, apiCfg.handlerUpdateNotificationPreferences))
+	mux.HandleFunc("POST /api/webhooks", apiCfg.middlewareAuth(auth.ScopeWebhooksManage, apiCfg.handlerCreateWebhookSubscription))
+	mux.HandleFunc("GET /api/webhooks", apiCfg.middlewareAuth(auth.ScopeWebhooksManage, apiCfg.handlerListWebhookSubscriptions))
+	mux.HandleFunc("DELETE /api/webhooks/{subscriptionID}", apiCfg.middlewareAuth(auth.ScopeWebhooksManage, apiCfg.handlerDeleteWebhookSubscription))
+	mux.HandleFunc("GET /api/webhooks/{subscriptionID}/deliveries", apiCfg.middlewareAuth(auth.ScopeWebhooksManage, apiCfg.handlerListWebhookDeliveries))
+	mux.HandleFunc("GET /api/webhooks/{subscriptionID}/deliveries/{deliveryID}", apiCfg.middlewareAuth(auth.ScopeWebhooksManage, apiCfg.handlerGetWebhookDelivery))
+	mux.HandleFunc("POST /api/webhooks/{subscriptionID}/deliveries/{deliveryID}/redeliver", apiCfg.middlewareAuth(auth.ScopeWebhooksManage, apiCfg.handlerRedeliverWebhook))
+
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.middlewareAuth(auth.ScopeTokensManage, apiCfg.handlerOAuthConsent))
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerOAuthToken)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.handlerOAuthIntrospect)
+	mux.HandleFunc("GET /oauth/clients/{clientID}", apiCfg.handlerGetOAuthClient)
 
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
 	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	mux.HandleFunc("POST /admin/users/{userID}/unlock", apiCfg.middlewareAdmin(apiCfg.handlerUnlockUser))
+	mux.HandleFunc("GET /admin/webhooks/events", apiCfg.middlewareAdmin(apiCfg.handlerListWebhookEvents))
+	mux.HandleFunc("POST /admin/webhooks/events/{eventID}/replay", apiCfg.middlewareAdmin(apiCfg.handlerReplayWebhookEvent))
+	mux.HandleFunc("POST /admin/oauth/clients", apiCfg.middlewareAdmin(apiCfg.handlerCreateOAuthClient))
+	mux.HandleFunc("GET /admin/oauth/clients", apiCfg.middlewareAdmin(apiCfg.handlerListOAuthClients))
+	mux.HandleFunc("DELETE /admin/oauth/clients/{clientID}", apiCfg.middlewareAdmin(apiCfg.handlerDeleteOAuthClient))
+	mux.HandleFunc("GET /admin/filter/rules", apiCfg.middlewareAdmin(apiCfg.handlerListFilterRules))
+	mux.HandleFunc("POST /admin/filter/rules", apiCfg.middlewareAdmin(apiCfg.handlerCreateFilterRule))
+	mux.HandleFunc("PUT /admin/filter/rules/{ruleID}", apiCfg.middlewareAdmin(apiCfg.handlerUpdateFilterRule))
+	mux.HandleFunc("DELETE /admin/filter/rules/{ruleID}", apiCfg.middlewareAdmin(apiCfg.handlerDeleteFilterRule))
+	mux.HandleFunc("GET /admin/moderation/flags", apiCfg.middlewareModerator(apiCfg.handlerListChirpFlags))
+	mux.HandleFunc("POST /admin/moderation/flags/{flagID}/resolve", apiCfg.middlewareModerator(apiCfg.handlerResolveChirpFlag))
+	mux.HandleFunc("GET /admin/moderation/chirps/deleted", apiCfg.middlewareModerator(apiCfg.handlerListDeletedChirps))
+	mux.HandleFunc("GET /admin/moderation/chirps/{chirpID}", apiCfg.middlewareModerator(apiCfg.handlerModeratorGetChirp))
+	mux.HandleFunc("PUT /admin/moderation/chirps/{chirpID}/warning", apiCfg.middlewareModerator(apiCfg.handlerApplyContentWarning))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
 		Handler: mux,
 	}
 
+	go runDaily(context.Background(), "expire-subscriptions", 3, apiCfg.expireLapsedSubscriptions)
+	go apiCfg.listenForStreamEvents(context.Background(), dbURL)
+	go runDaily(context.Background(), "prune-outbox", 4, apiCfg.pruneOutbox)
+	go runDaily(context.Background(), "purge-trashed-chirps", 5, apiCfg.purgeTrashedChirps)
+	go runEvery(context.Background(), "dispatch-outbox", time.Second, apiCfg.dispatchOutbox)
+	go runEvery(context.Background(), "deliver-webhooks", 5*time.Second, apiCfg.deliverWebhooks)
+	go runEvery(context.Background(), "fetch-link-previews", 5*time.Second, apiCfg.fetchLinkPreviews)
+	go runEvery(context.Background(), "flush-chirp-views", 5*time.Second, apiCfg.flushChirpViews)
+	go runEvery(context.Background(), "rollup-chirp-views", time.Minute, apiCfg.rollupChirpViews)
+	// Rules changes are also notified, this catches any notification missed
+	go runEvery(context.Background(), "reload-content-filter", time.Minute, apiCfg.reloadContentFilter)
+	go func() {
+		for range time.Tick(10 * time.Minute) {
+			apiCfg.RateLimiter.Prune(time.Hour)
+		}
+	}()
+
 	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
 	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/auth"
+)
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	UserID uuid.UUID
+	// Scopes is nil for the user's own session (JWT from /api/login),
+	// which is allowed to do everything.
+	Scopes []auth.Scope
+	// PersonalTokenID is set when the request used a personal access token.
+	PersonalTokenID uuid.NullUUID
+	// OAuthTokenID is set when the request used a token issued to an OAuth client.
+	OAuthTokenID uuid.NullUUID
+}
+
+func (p principal) HasScope(scope auth.Scope) bool {
+	if p.Scopes == nil {
+		return true
+	}
+
+	return slices.Contains(p.Scopes, scope)
+}
+
+// scopesFromStrings converts scopes loaded from the database, which were
+// validated when they were stored.
+func scopesFromStrings(names []string) []auth.Scope {
+	scopes := make([]auth.Scope, len(names))
+	for i, name := range names {
+		scopes[i] = auth.Scope(name)
+	}
+
+	return scopes
+}
+
+type principalContextKey struct{}
+
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(principal)
+	return p, ok
+}
+
+// middlewareAuth authenticates the request with either a JWT (the user's
+// session or an OAuth access token) or a personal access token and rejects it unless the caller was granted scope.
+// Handlers read the caller with principalFromContext.
+func (cfg *apiConfig) middlewareAuth(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Authentication error: couldn't get access token", err)
+			return
+		}
+
+		caller, err := cfg.authenticate(r.Context(), token)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Authentication error: invalid or expired token", err)
+			return
+		}
+
+		if !caller.HasScope(scope) {
+			respondWithError(w, http.StatusForbidden, "Forbidden: token is missing the "+string(scope)+" scope", nil)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, caller)))
+	}
+}
+
+// middlewareOptionalAuth lets anonymous requests through, requests with a
+// token are authenticated like in middlewareAuth.
+func (cfg *apiConfig) middlewareOptionalAuth(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	authenticated := cfg.middlewareAuth(scope, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next(w, r)
+			return
+		}
+
+		authenticated(w, r)
+	}
+}
+
+// viewerFromContext returns the caller reading chirps, which is not set for
+// anonymous requests.
+func viewerFromContext(ctx context.Context) uuid.NullUUID {
+	caller, ok := principalFromContext(ctx)
+	if !ok {
+		return uuid.NullUUID{}
+	}
+
+	return uuid.NullUUID{UUID: caller.UserID, Valid: true}
+}
+
+func (cfg *apiConfig) authenticate(ctx context.Context, token string) (principal, error) {
+	if !auth.IsPersonalAccessToken(token) {
+		return cfg.authenticateJWT(ctx, token)
+	}
+
+	pat, err := cfg.DB.GetActivePersonalAccessToken(ctx, auth.HashToken(token))
+	if err != nil {
+		return principal{}, err
+	}
+
+	if err := cfg.DB.TouchPersonalAccessToken(ctx, pat.ID); err != nil {
+		// Last-used tracking is informative only, don't fail the request for it
+		log.Printf("couldn't update last use of token %s: %v", pat.ID, err)
+	}
+
+	return principal{
+		UserID:          pat.UserID,
+		Scopes:          scopesFromStrings(pat.Scopes),
+		PersonalTokenID: uuid.NullUUID{UUID: pat.ID, Valid: true},
+	}, nil
+}
+
+func (cfg *apiConfig) authenticateJWT(ctx context.Context, token string) (principal, error) {
+	claims, err := auth.ParseJWT(token, cfg.SECRET, cfg.TokenPolicy)
+	if err != nil {
+		return principal{}, err
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return principal{}, err
+	}
+
+	if !claims.Delegated() {
+		return principal{UserID: userID}, nil
+	}
+
+	// OAuth access tokens can be revoked before they expire
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return principal{}, err
+	}
+
+	grant, err := cfg.DB.GetOAuthToken(ctx, tokenID)
+	if err != nil {
+		return principal{}, err
+	}
+
+	if grant.RevokedAt.Valid || grant.UserID != userID {
+		return principal{}, errors.New("oauth token has been revoked")
+	}
+
+	scopes, err := auth.ParseScopeString(claims.Scope)
+	if err != nil {
+		return principal{}, err
+	}
+
+	return principal{
+		UserID:       userID,
+		Scopes:       scopes,
+		OAuthTokenID: uuid.NullUUID{UUID: tokenID, Valid: true},
+	}, nil
+}
+
+// middlewareAdmin only lets through requests made by an admin with their
+// own session.
+func (cfg *apiConfig) middlewareAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareRole("Forbidden: admin only", next, roleAdmin)
+}
+
+// middlewareModerator lets moderators and admins through.
+func (cfg *apiConfig) middlewareModerator(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareRole("Forbidden: moderators only", next, roleModerator, roleAdmin)
+}
+
+func (cfg *apiConfig) middlewareRole(forbidden string, next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return cfg.middlewareAuth(auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromContext(r.Context())
+
+		user, err := cfg.DB.GetUserByID(r.Context(), caller.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Authentication error: user not found", err)
+			return
+		}
+
+		if !slices.Contains(roles, user.Role) {
+			respondWithError(w, http.StatusForbidden, forbidden, nil)
+			return
+		}
+
+		next(w, r)
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/outbox"
+)
+
+// Notification types, each one is produced by an outbox subscriber below.
+const (
+	notificationReply         = "reply"
+	notificationLike          = "like"
+	notificationFollow        = "follow"
+	notificationFollowRequest = "follow_request"
+	notificationMention       = "mention"
+)
+
+var notificationTypes = []string{
+	notificationReply,
+	notificationLike,
+	notificationFollow,
+	notificationFollowRequest,
+	notificationMention,
+}
+
+// notification is something that happened to a user's content or account.
+// TargetID is the chirp it is about, if any.
+type notification struct {
+	UserID   uuid.UUID
+	Type     string
+	ActorID  uuid.UUID
+	TargetID uuid.NullUUID
+}
+
+// notify records n unless the user turned off that type of notification.
+// Unread notifications of the same type about the same target are grouped
+// ("Someone and 3 others liked your chirp"). The notification and its event are
+// written with q, so both or neither are stored.
+func notify(ctx context.Context, q *database.Queries, n notification) error {
+	if n.ActorID == n.UserID {
+		return nil
+	}
+
+	enabled, err := q.IsNotificationEnabled(ctx, database.IsNotificationEnabledParams{
+		UserID: n.UserID,
+		Type:   n.Type,
+	})
+	if err != nil || !enabled {
+		return err
+	}
+
+	row, err := q.UpsertNotification(ctx, database.UpsertNotificationParams{
+		UserID:   n.UserID,
+		Type:     n.Type,
+		TargetID: n.TargetID,
+		GroupKey: notificationGroupKey(n),
+		ActorID:  n.ActorID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return publishEvent(ctx, q, eventNotificationCreated, mapNotification(row))
+}
+
+// notifyFollow is the notifications subscriber of the follow events.
+// Notifying the same actor again is a no-op while the notification is
+// unread, so redelivered events don't add actors.
+func (cfg *apiConfig) notifyFollow(ctx context.Context, event outbox.Event) error {
+	var follow FollowEvent
+	if err := json.Unmarshal(event.Payload, &follow); err != nil {
+		return err
+	}
+
+	notificationType := notificationFollow
+	if event.Type == eventFollowRequested {
+		notificationType = notificationFollowRequest
+	}
+
+	return cfg.DB.InTx(ctx, func(q *database.Queries) error {
+		return notify(ctx, q, notification{
+			UserID:  follow.UserID,
+			Type:    notificationType,
+			ActorID: follow.FollowerID,
+		})
+	})
+}
+
+// notifyMention is the notifications subscriber of webhooks.EventUserMentioned.
+func (cfg *apiConfig) notifyMention(ctx context.Context, event outbox.Event) error {
+	var mention UserMentionedEvent
+	if err := json.Unmarshal(event.Payload, &mention); err != nil {
+		return err
+	}
+
+	return cfg.DB.InTx(ctx, func(q *database.Queries) error {
+		return notify(ctx, q, notification{
+			UserID:   mention.UserID,
+			Type:     notificationMention,
+			ActorID:  mention.Chirp.UserID,
+			TargetID: uuid.NullUUID{UUID: mention.Chirp.ID, Valid: true},
+		})
+	})
+}
+
+// notifyReply is the notifications subscriber of eventChirpReplied. All the
+// replies to a chirp are grouped.
+func (cfg *apiConfig) notifyReply(ctx context.Context, event outbox.Event) error {
+	var reply ChirpRepliedEvent
+	if err := json.Unmarshal(event.Payload, &reply); err != nil {
+		return err
+	}
+	if reply.Chirp.ReplyToID == nil {
+		return nil
+	}
+
+	return cfg.DB.InTx(ctx, func(q *database.Queries) error {
+		return notify(ctx, q, notification{
+			UserID:   reply.UserID,
+			Type:     notificationReply,
+			ActorID:  reply.Chirp.UserID,
+			TargetID: uuid.NullUUID{UUID: *reply.Chirp.ReplyToID, Valid: true},
+		})
+	})
+}
+
+// notifyLike is the notifications subscriber of eventChirpLiked.
+func (cfg *apiConfig) notifyLike(ctx context.Context, event outbox.Event) error {
+	var like ChirpLikedEvent
+	if err := json.Unmarshal(event.Payload, &like); err != nil {
+		return err
+	}
+
+	return cfg.DB.InTx(ctx, func(q *database.Queries) error {
+		return notify(ctx, q, notification{
+			UserID:   like.UserID,
+			Type:     notificationLike,
+			ActorID:  like.LikerID,
+			TargetID: uuid.NullUUID{UUID: like.ChirpID, Valid: true},
+		})
+	})
+}
+
+func notificationGroupKey(n notification) string {
+	switch n.Type {
+	case notificationFollow, notificationFollowRequest:
+		// All new followers go in the same notification
+		return n.Type
+	case notificationMention:
+		// Every mention is worth its own notification
+		return n.Type + ":" + n.TargetID.UUID.String() + ":" + n.ActorID.String()
+	default:
+		return n.Type + ":" + n.TargetID.UUID.String()
+	}
+}
+
+// notificationSummary describes a notification. Users don't have display
+// names, actors are listed separately in the response.
+func notificationSummary(notificationType string, actorCount int32) string {
+	who := "Someone"
+	switch {
+	case actorCount == 2:
+		who = "Someone and 1 other"
+	case actorCount > 2:
+		who = fmt.Sprintf("Someone and %d others", actorCount-1)
+	}
+
+	switch notificationType {
+	case notificationReply:
+		return who + " replied to your chirp"
+	case notificationLike:
+		return who + " liked your chirp"
+	case notificationFollow:
+		return who + " followed you"
+	case notificationFollowRequest:
+		return who + " requested to follow you"
+	case notificationMention:
+		return who + " mentioned you"
+	}
+
+	return who + " interacted with you"
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// parsePagination reads the limit and offset query parameters.
+func parsePagination(r *http.Request) (int32, int32, error) {
+	const defaultLimit, maxLimit = 50, 100
+
+	limit, offset := int32(defaultLimit), int32(0)
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			return 0, 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxLimit))
+		}
+		limit = int32(parsed)
+	}
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		// Parsed as 32 bits so a bigger offset is an error, not a wrapped one
+		parsed, err := strconv.ParseInt(raw, 10, 32)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("offset must be a positive number up to " + strconv.Itoa(math.MaxInt32))
+		}
+		offset = int32(parsed)
+	}
+
+	return limit, offset, nil
+}
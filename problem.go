@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Error codes are part of the API, clients match on them instead of on the
+// messages, so they must not change once published.
+const (
+	problemInvalidBody        = "invalid_body"
+	problemValidationFailed   = "validation_failed"
+	problemEmailTaken         = "email_taken"
+	problemInvalidCredentials = "invalid_credentials"
+	problemTooManyAttempts    = "too_many_attempts"
+	problemContentRejected    = "content_rejected"
+	problemInvalidSignature   = "invalid_signature"
+	problemUserNotFound       = "user_not_found"
+	problemInvalidChirpID     = "invalid_chirp_id"
+	problemChirpNotFound      = "chirp_not_found"
+	problemNotChirpAuthor     = "not_chirp_author"
+	problemRequiresRed        = "requires_chirpy_red"
+	problemInternal           = "internal_error"
+)
+
+// Codes for the errors of a single field
+const (
+	fieldRequired     = "required"
+	fieldInvalid      = "invalid"
+	fieldTooShort     = "too_short"
+	fieldTooLong      = "too_long"
+	fieldTooMany      = "too_many"
+	fieldBreached     = "breached"
+	fieldInvalidEmail = "invalid_email"
+)
+
+// problem is an RFC 7807 problem details response. Problems don't have a
+// documentation page, so the type is always about:blank and the code
+// extension member identifies the problem.
+type problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   string       `json:"code"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// validator collects the errors found in a request, so all of them are
+// reported at once.
+type validator struct {
+	errors []fieldError
+}
+
+func (v *validator) check(ok bool, field, code, message string) {
+	if !ok {
+		v.add(field, code, message)
+	}
+}
+
+func (v *validator) add(field, code, message string) {
+	v.errors = append(v.errors, fieldError{Field: field, Code: code, Message: message})
+}
+
+func (v *validator) valid() bool {
+	return len(v.errors) == 0
+}
+
+func respondWithProblem(w http.ResponseWriter, status int, code, detail string, err error) {
+	writeProblem(w, problem{Status: status, Code: code, Detail: detail}, err)
+}
+
+func respondWithValidationProblem(w http.ResponseWriter, v *validator) {
+	writeProblem(w, problem{
+		Status: http.StatusBadRequest,
+		Code:   problemValidationFailed,
+		Detail: "The request has invalid fields",
+		Errors: v.errors,
+	}, nil)
+}
+
+func writeProblem(w http.ResponseWriter, p problem, err error) {
+	if err != nil {
+		log.Println(err)
+	}
+	if p.Status > 499 {
+		log.Printf("Responding with 5XX error: %s", p.Detail)
+	}
+
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+
+	dat, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(dat)
+}
@@ -1,27 +1,122 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, media_urls, content_warning, sensitive, visibility, reply_to_id)
 VALUES (
     gen_random_uuid (),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
 )
 RETURNING *;
 
 -- name: GetChirps :many
 SELECT * FROM chirps
+WHERE deleted_at IS NULL
+    AND chirp_visible_to(id, user_id, visibility, sqlc.narg(viewer_id))
+    AND (sqlc.narg(sensitive)::bool IS NULL OR sensitive = sqlc.narg(sensitive))
+    AND (sqlc.narg(has_warning)::bool IS NULL OR (content_warning IS NOT NULL) = sqlc.narg(has_warning))
 ORDER BY created_at ASC;
 
 -- name: GetChirpsByAuthor :many
 SELECT * FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC;
+WHERE user_id = sqlc.arg(user_id)
+    AND deleted_at IS NULL
+    AND chirp_visible_to(id, user_id, visibility, sqlc.narg(viewer_id))
+    AND (sqlc.narg(sensitive)::bool IS NULL OR sensitive = sqlc.narg(sensitive))
+    AND (sqlc.narg(has_warning)::bool IS NULL OR (content_warning IS NOT NULL) = sqlc.narg(has_warning))
+ORDER BY pinned_at IS NULL, pinned_at DESC, created_at ASC;
 
 -- name: GetChirp :one
 SELECT * FROM chirps
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetChirpIncludingDeleted :one
+SELECT * FROM chirps
 WHERE id = $1;
 
--- name: DeleteChirp :exec
+-- name: GetVisibleChirp :one
+SELECT * FROM chirps
+WHERE id = sqlc.arg(id)
+    AND deleted_at IS NULL
+    AND chirp_visible_to(id, user_id, visibility, sqlc.narg(viewer_id));
+
+-- name: IsChirpVisible :one
+SELECT chirp_visible_to(sqlc.arg(chirp_id), sqlc.arg(author_id), sqlc.arg(visibility)::text, sqlc.narg(viewer_id))::bool AS visible;
+
+-- name: AddChirpMentions :exec
+INSERT INTO chirp_mentions (chirp_id, user_id)
+SELECT sqlc.arg(chirp_id), mentioned
+FROM unnest(sqlc.arg(user_ids)::uuid[]) AS mentioned
+ON CONFLICT DO NOTHING;
+
+-- name: SoftDeleteChirp :one
+UPDATE chirps
+SET deleted_at = NOW(), pinned_at = NULL
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL
+WHERE id = sqlc.arg(id) AND deleted_at > sqlc.arg(deleted_after)
+RETURNING *;
+
+-- name: GetTrashedChirps :many
+SELECT * FROM chirps
+WHERE user_id = sqlc.arg(user_id) AND deleted_at > sqlc.arg(deleted_after)
+ORDER BY deleted_at DESC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
+
+-- name: ListDeletedChirps :many
+SELECT * FROM chirps
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
+
+-- name: PurgeDeletedChirps :execrows
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE deleted_at < sqlc.arg(deleted_before)
+    AND NOT EXISTS (
+        SELECT 1 FROM chirp_flags
+        WHERE chirp_flags.chirp_id = chirps.id AND chirp_flags.resolved_at IS NULL
+    );
+
+-- name: UpdateChirp :one
+UPDATE chirps
+SET body = $2, content_warning = $3, sensitive = $4, updated_at = NOW()
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: ApplyContentWarning :one
+UPDATE chirps
+SET content_warning = $2,
+    sensitive = $3,
+    warning_applied_by = $4,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: LockUserPins :exec
+SELECT 1 FROM users
+WHERE id = $1
+FOR NO KEY UPDATE;
+
+-- name: PinChirp :execrows
+UPDATE chirps
+SET pinned_at = NOW()
+WHERE id = sqlc.arg(id)
+    AND deleted_at IS NULL
+    AND pinned_at IS NULL
+    AND (SELECT count(*) FROM chirps pinned WHERE pinned.user_id = chirps.user_id AND pinned.pinned_at IS NOT NULL) < sqlc.arg(max_pinned)::int;
+
+-- name: UnpinChirp :exec
+UPDATE chirps
+SET pinned_at = NULL
+WHERE id = $1;
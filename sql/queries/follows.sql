@@ -0,0 +1,60 @@
+-- name: FollowUser :execrows
+INSERT INTO follows (follower_id, followee_id, created_at, status)
+VALUES ($1, $2, NOW(), $3)
+ON CONFLICT DO NOTHING;
+
+-- name: GetFollowStatus :one
+SELECT status FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: ListFollowRequests :many
+SELECT follower_id, created_at FROM follows
+WHERE followee_id = $1 AND status = 'pending'
+ORDER BY created_at ASC
+LIMIT $2
+OFFSET $3;
+
+-- name: AcceptFollowRequest :execrows
+UPDATE follows
+SET status = 'accepted'
+WHERE follower_id = $1 AND followee_id = $2 AND status = 'pending';
+
+-- name: RejectFollowRequest :execrows
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2 AND status = 'pending';
+
+-- name: AcceptAllFollowRequests :exec
+UPDATE follows
+SET status = 'accepted'
+WHERE followee_id = $1 AND status = 'pending';
+
+-- name: UnfollowUser :execrows
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: IsFollowing :one
+SELECT EXISTS (
+    SELECT 1 FROM follows
+    WHERE follower_id = $1 AND followee_id = $2 AND status = 'accepted'
+);
+
+-- name: BlockUser :exec
+INSERT INTO user_blocks (blocker_id, blocked_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: UnblockUser :execrows
+DELETE FROM user_blocks
+WHERE blocker_id = $1 AND blocked_id = $2;
+
+-- name: IsBlockedEitherWay :one
+SELECT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = $1 AND blocked_id = $2)
+        OR (blocker_id = $2 AND blocked_id = $1)
+);
+
+-- name: RemoveFollowsBetween :exec
+DELETE FROM follows
+WHERE (follower_id = $1 AND followee_id = $2)
+    OR (follower_id = $2 AND followee_id = $1);
@@ -0,0 +1,34 @@
+-- name: QueueLinkPreviews :exec
+INSERT INTO link_previews (url, created_at, updated_at, status, next_attempt_at)
+SELECT url, NOW(), NOW(), 'pending', NOW()
+FROM unnest(sqlc.arg(urls)::text[]) AS url
+ON CONFLICT (url) DO NOTHING;
+
+-- name: ClaimDueLinkPreviews :many
+UPDATE link_previews
+SET next_attempt_at = sqlc.arg(lease_until)
+WHERE url IN (
+    SELECT url FROM link_previews
+    WHERE status = 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: FinishLinkPreview :exec
+UPDATE link_previews
+SET status = $2,
+    title = $3,
+    description = $4,
+    image_url = $5,
+    error = $6,
+    next_attempt_at = $7,
+    fetched_at = $8,
+    attempts = attempts + 1,
+    updated_at = NOW()
+WHERE url = $1;
+
+-- name: GetLinkPreviews :many
+SELECT * FROM link_previews
+WHERE url = ANY (sqlc.arg(urls)::text[]) AND status = 'ready';
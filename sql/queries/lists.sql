@@ -0,0 +1,69 @@
+-- name: CreateList :one
+INSERT INTO lists (id, created_at, updated_at, owner_id, name, is_private)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3)
+RETURNING *;
+
+-- name: GetList :one
+SELECT * FROM lists
+WHERE id = $1;
+
+-- name: GetUserLists :many
+SELECT * FROM lists
+WHERE owner_id = sqlc.arg(user_id)
+    OR (NOT is_private AND id IN (SELECT list_id FROM list_subscriptions WHERE user_id = sqlc.arg(user_id)))
+ORDER BY created_at ASC;
+
+-- name: UpdateList :one
+UPDATE lists
+SET name = $2, is_private = $3, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteList :exec
+DELETE FROM lists
+WHERE id = $1;
+
+-- name: AddListMember :exec
+INSERT INTO list_members (list_id, user_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveListMember :execrows
+DELETE FROM list_members
+WHERE list_id = $1 AND user_id = $2;
+
+-- name: CountListMembers :one
+SELECT count(*) FROM list_members
+WHERE list_id = $1;
+
+-- name: ListListMembers :many
+SELECT user_id, created_at FROM list_members
+WHERE list_id = sqlc.arg(list_id)
+ORDER BY created_at ASC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
+
+-- name: SubscribeToList :exec
+INSERT INTO list_subscriptions (list_id, user_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: UnsubscribeFromList :execrows
+DELETE FROM list_subscriptions
+WHERE list_id = $1 AND user_id = $2;
+
+-- name: DeleteListSubscriptions :exec
+DELETE FROM list_subscriptions
+WHERE list_id = $1;
+
+-- name: GetListChirps :many
+SELECT chirps.* FROM chirps
+JOIN list_members ON list_members.user_id = chirps.user_id
+WHERE list_members.list_id = sqlc.arg(list_id)
+    AND chirps.deleted_at IS NULL
+    AND chirp_visible_to(chirps.id, chirps.user_id, chirps.visibility, sqlc.narg(viewer_id))
+ORDER BY
+    CASE WHEN sqlc.arg(newest_first)::bool THEN chirps.created_at END DESC,
+    chirps.created_at ASC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
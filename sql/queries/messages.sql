@@ -0,0 +1,79 @@
+-- name: CreateConversation :one
+INSERT INTO conversations (id, created_at, updated_at, is_group)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    NOW(),
+    $1
+)
+RETURNING *;
+
+-- name: AddConversationMember :exec
+INSERT INTO conversation_members (conversation_id, user_id, joined_at)
+VALUES ($1, $2, NOW());
+
+-- name: GetDirectConversation :one
+SELECT conversations.* FROM conversations
+JOIN conversation_members a ON a.conversation_id = conversations.id AND a.user_id = sqlc.arg(user_id)
+JOIN conversation_members b ON b.conversation_id = conversations.id AND b.user_id = sqlc.arg(other_user_id)
+WHERE NOT conversations.is_group;
+
+-- name: GetConversationForMember :one
+SELECT conversations.* FROM conversations
+JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+WHERE conversations.id = $1 AND conversation_members.user_id = $2;
+
+-- name: ListConversations :many
+SELECT
+    conversations.*,
+    (
+        SELECT COUNT(*) FROM messages
+        WHERE messages.conversation_id = conversations.id
+            AND messages.sender_id <> sqlc.arg(user_id)
+            AND (me.last_read_at IS NULL OR messages.created_at > me.last_read_at)
+    ) AS unread_count
+FROM conversations
+JOIN conversation_members me ON me.conversation_id = conversations.id AND me.user_id = sqlc.arg(user_id)
+ORDER BY conversations.updated_at DESC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
+
+-- name: ListConversationMembers :many
+SELECT user_id FROM conversation_members
+WHERE conversation_id = $1
+ORDER BY joined_at ASC, user_id ASC;
+
+-- name: CreateMessage :one
+INSERT INTO messages (id, created_at, conversation_id, sender_id, body_encrypted)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: TouchConversation :exec
+UPDATE conversations
+SET updated_at = NOW()
+WHERE id = $1;
+
+-- name: ListMessages :many
+SELECT * FROM messages
+WHERE conversation_id = sqlc.arg(conversation_id)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
+
+-- name: MarkConversationRead :exec
+UPDATE conversation_members
+SET last_read_at = NOW()
+WHERE conversation_id = $1 AND user_id = $2;
+
+-- name: SharesConversation :one
+SELECT EXISTS (
+    SELECT 1 FROM conversation_members a
+    JOIN conversation_members b ON b.conversation_id = a.conversation_id
+    WHERE a.user_id = sqlc.arg(user_id) AND b.user_id = sqlc.arg(other_user_id)
+);
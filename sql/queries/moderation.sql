@@ -0,0 +1,50 @@
+-- name: ListFilterRules :many
+SELECT * FROM filter_rules
+ORDER BY term ASC;
+
+-- name: CreateFilterRule :one
+INSERT INTO filter_rules (id, created_at, updated_at, term, action)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING *;
+
+-- name: UpdateFilterRule :one
+UPDATE filter_rules
+SET term = $2,
+    action = $3,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteFilterRule :execrows
+DELETE FROM filter_rules
+WHERE id = $1;
+
+-- name: CreateChirpFlag :exec
+INSERT INTO chirp_flags (id, created_at, chirp_id, rule_id, term)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    $1,
+    $2,
+    $3
+);
+
+-- name: ListChirpFlags :many
+SELECT * FROM chirp_flags
+WHERE NOT sqlc.arg(unresolved_only)::bool OR resolved_at IS NULL
+ORDER BY created_at DESC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
+
+-- name: ResolveChirpFlag :one
+UPDATE chirp_flags
+SET resolved_at = NOW(),
+    resolved_by = $2
+WHERE id = $1 AND resolved_at IS NULL
+RETURNING *;
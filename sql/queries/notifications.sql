@@ -0,0 +1,52 @@
+-- name: UpsertNotification :one
+INSERT INTO notifications (id, created_at, updated_at, user_id, type, target_id, group_key, actor_ids, actor_count)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    NOW(),
+    sqlc.arg(user_id),
+    sqlc.arg(type),
+    sqlc.arg(target_id),
+    sqlc.arg(group_key),
+    ARRAY[sqlc.arg(actor_id)::uuid],
+    1
+)
+ON CONFLICT (user_id, group_key) WHERE read_at IS NULL DO UPDATE
+SET updated_at = NOW(),
+    actor_ids = ARRAY[sqlc.arg(actor_id)::uuid] || array_remove(notifications.actor_ids, sqlc.arg(actor_id)::uuid),
+    actor_count = notifications.actor_count + CASE WHEN sqlc.arg(actor_id)::uuid = ANY (notifications.actor_ids) THEN 0 ELSE 1 END
+RETURNING *;
+
+-- name: ListNotifications :many
+SELECT * FROM notifications
+WHERE user_id = sqlc.arg(user_id)
+    AND (NOT sqlc.arg(unread_only)::bool OR read_at IS NULL)
+ORDER BY updated_at DESC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
+
+-- name: MarkNotificationRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE id = $1 AND user_id = $2 AND read_at IS NULL;
+
+-- name: MarkAllNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: ListNotificationPreferences :many
+SELECT * FROM notification_preferences
+WHERE user_id = $1;
+
+-- name: SetNotificationPreference :exec
+INSERT INTO notification_preferences (user_id, type, enabled)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, type) DO UPDATE
+SET enabled = EXCLUDED.enabled;
+
+-- name: IsNotificationEnabled :one
+SELECT COALESCE((
+    SELECT enabled FROM notification_preferences
+    WHERE user_id = $1 AND type = $2
+), TRUE)::bool AS enabled;
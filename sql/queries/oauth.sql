@@ -0,0 +1,77 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, name, secret_hash, redirect_uris, scopes)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT * FROM oauth_clients
+ORDER BY created_at ASC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, created_at, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: ConsumeOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = sqlc.arg(code_hash)
+  AND client_id = sqlc.arg(client_id)
+  AND redirect_uri = sqlc.arg(redirect_uri)
+  AND used_at IS NULL
+  AND expires_at > NOW()
+RETURNING *;
+
+-- name: CreateOAuthToken :one
+INSERT INTO oauth_tokens (id, created_at, updated_at, client_id, user_id, scopes, refresh_token_hash, access_expires_at, refresh_expires_at)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING *;
+
+-- name: GetOAuthToken :one
+SELECT * FROM oauth_tokens
+WHERE id = $1;
+
+-- name: GetOAuthTokenByRefreshHash :one
+SELECT * FROM oauth_tokens
+WHERE refresh_token_hash = $1;
+
+-- name: RevokeOAuthToken :execrows
+UPDATE oauth_tokens
+SET updated_at = NOW(),
+    revoked_at = NOW()
+WHERE id = $1
+  AND revoked_at IS NULL;
\ No newline at end of file
@@ -0,0 +1,44 @@
+-- name: CreateOutboxEvent :exec
+INSERT INTO outbox (id, created_at, event_type, payload, next_attempt_at)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    $1,
+    $2,
+    NOW()
+);
+
+-- name: ClaimOutboxEvents :many
+UPDATE outbox
+SET next_attempt_at = sqlc.arg(lease_until)
+WHERE id IN (
+    SELECT id FROM outbox
+    WHERE published_at IS NULL AND next_attempt_at <= NOW()
+    ORDER BY created_at
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkOutboxEventPublished :exec
+UPDATE outbox
+SET published_at = NOW(), attempts = attempts + 1, last_error = NULL
+WHERE id = $1;
+
+-- name: FailOutboxEvent :exec
+UPDATE outbox
+SET next_attempt_at = $2, last_error = $3, attempts = attempts + 1
+WHERE id = $1;
+
+-- name: DeletePublishedOutboxEvents :execrows
+DELETE FROM outbox
+WHERE published_at < $1;
+
+-- name: ListOutboxEventsAfter :many
+SELECT * FROM outbox
+WHERE seq > sqlc.arg(after_seq) AND seq <= sqlc.arg(until_seq)
+ORDER BY seq ASC
+LIMIT sqlc.arg(limit_count);
+
+-- name: GetLatestOutboxSeq :one
+SELECT COALESCE(MAX(seq), 0)::bigint AS seq FROM outbox;
@@ -0,0 +1,2 @@
+-- name: NotifyTyping :exec
+SELECT pg_notify('typing_events', sqlc.arg(payload)::text);
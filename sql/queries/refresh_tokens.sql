@@ -1,12 +1,13 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at, last_used_at)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
     $3,
-    $4
+    $4,
+    NOW()
 )
 RETURNING *;
 
@@ -14,7 +15,14 @@ RETURNING *;
 SELECT user_id FROM refresh_tokens
 WHERE token = $1
   AND revoked_at IS NULL
-  AND expires_at > NOW();
+  AND expires_at > NOW()
+  AND last_used_at > $2;
+
+-- name: TouchRefreshToken :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(),
+    last_used_at = NOW()
+WHERE token = $1;
 
 -- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
@@ -1,5 +1,5 @@
 -- name: ResetChirps :exec
-TRUNCATE TABLE chirps;
+TRUNCATE TABLE chirps CASCADE;
 
 -- name: ResetUsers :exec
 TRUNCATE TABLE users CASCADE;
\ No newline at end of file
@@ -0,0 +1,64 @@
+-- name: GetSubscriptionByUser :one
+SELECT * FROM subscriptions
+WHERE user_id = $1;
+
+-- name: GetSubscriptionByUserForUpdate :one
+SELECT * FROM subscriptions
+WHERE user_id = $1
+FOR UPDATE;
+
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, current_period_end, grace_period_end)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    current_period_end = EXCLUDED.current_period_end,
+    grace_period_end = EXCLUDED.grace_period_end,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: CreateSubscriptionHistory :exec
+INSERT INTO subscription_history (id, created_at, subscription_id, event, status, current_period_end, webhook_event_id)
+VALUES (
+    gen_random_uuid (),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+);
+
+-- name: ListSubscriptionHistory :many
+SELECT * FROM subscription_history
+WHERE subscription_id = $1
+ORDER BY created_at ASC;
+
+-- name: IsChirpyRed :one
+SELECT EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE user_id = $1
+      AND (
+        (status = 'active' AND current_period_end > NOW())
+        OR (status = 'past_due' AND grace_period_end > NOW())
+      )
+);
+
+-- name: ExpireLapsedSubscriptions :many
+UPDATE subscriptions
+SET status = 'expired',
+    grace_period_end = NULL,
+    updated_at = NOW()
+WHERE (status = 'active' AND current_period_end <= NOW())
+   OR (status = 'past_due' AND grace_period_end <= NOW())
+RETURNING *;
\ No newline at end of file
@@ -1,12 +1,11 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
     gen_random_uuid (),
     NOW(),
     NOW(),
     $1,
-    $2,
-    DEFAULT
+    $2
 )
 RETURNING *;
 
@@ -21,7 +20,28 @@ SET email = $2,
 WHERE id = $1
 RETURNING *;
 
--- name: UpgradeUser :exec
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: UpdatePasswordHash :exec
+UPDATE users
+SET hashed_password = $2,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdatePrivacy :one
+UPDATE users
+SET dm_policy = $2,
+    is_private = $3,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateContentPreferences :one
 UPDATE users
-SET is_chirpy_red = true
-WHERE id = $1;
\ No newline at end of file
+SET expand_content_warnings = $2,
+    show_sensitive_media = $3,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
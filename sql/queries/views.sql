@@ -0,0 +1,64 @@
+-- name: RecordChirpViews :execrows
+INSERT INTO chirp_view_events (chirp_id, kind, viewer, window_start)
+SELECT recorded.chirp_id, recorded.kind, recorded.viewer, sqlc.arg(window_start)
+FROM unnest(sqlc.arg(chirp_ids)::uuid[], sqlc.arg(kinds)::text[], sqlc.arg(viewers)::text[]) AS recorded (chirp_id, kind, viewer)
+JOIN chirps ON chirps.id = recorded.chirp_id
+ON CONFLICT DO NOTHING;
+
+-- name: RollupChirpViews :execrows
+WITH claimed AS (
+    UPDATE chirp_view_events
+    SET rolled_up = true
+    WHERE NOT rolled_up
+    RETURNING chirp_id, kind, window_start
+)
+INSERT INTO chirp_view_rollups (chirp_id, hour, impressions, views)
+SELECT chirp_id,
+    window_start,
+    count(*) FILTER (WHERE kind = 'impression'),
+    count(*) FILTER (WHERE kind = 'view')
+FROM claimed
+GROUP BY chirp_id, window_start
+ON CONFLICT (chirp_id, hour) DO UPDATE
+SET impressions = chirp_view_rollups.impressions + EXCLUDED.impressions,
+    views = chirp_view_rollups.views + EXCLUDED.views;
+
+-- name: PruneChirpViewEvents :execrows
+DELETE FROM chirp_view_events
+WHERE rolled_up AND window_start < $1;
+
+-- name: GetChirpViewTotals :one
+SELECT coalesce(sum(impressions), 0)::bigint AS impressions,
+    coalesce(sum(views), 0)::bigint AS views
+FROM chirp_view_rollups
+WHERE chirp_id = $1;
+
+-- name: GetChirpHourlyViews :many
+SELECT hour, impressions, views FROM chirp_view_rollups
+WHERE chirp_id = sqlc.arg(chirp_id) AND hour >= sqlc.arg(since)
+ORDER BY hour ASC;
+
+-- name: GetAuthorHourlyViews :many
+SELECT chirp_view_rollups.hour,
+    sum(chirp_view_rollups.impressions)::bigint AS impressions,
+    sum(chirp_view_rollups.views)::bigint AS views
+FROM chirp_view_rollups
+JOIN chirps ON chirps.id = chirp_view_rollups.chirp_id
+WHERE chirps.user_id = sqlc.arg(user_id)
+    AND chirps.deleted_at IS NULL
+    AND chirp_view_rollups.hour >= sqlc.arg(since)
+GROUP BY chirp_view_rollups.hour
+ORDER BY chirp_view_rollups.hour ASC;
+
+-- name: GetAuthorTopChirps :many
+SELECT chirp_view_rollups.chirp_id,
+    sum(chirp_view_rollups.impressions)::bigint AS impressions,
+    sum(chirp_view_rollups.views)::bigint AS views
+FROM chirp_view_rollups
+JOIN chirps ON chirps.id = chirp_view_rollups.chirp_id
+WHERE chirps.user_id = sqlc.arg(user_id)
+    AND chirps.deleted_at IS NULL
+    AND chirp_view_rollups.hour >= sqlc.arg(since)
+GROUP BY chirp_view_rollups.chirp_id
+ORDER BY views DESC, impressions DESC, chirp_view_rollups.chirp_id ASC
+LIMIT sqlc.arg(limit_count);
@@ -0,0 +1,40 @@
+-- name: CreateWebhookEvent :one
+INSERT INTO webhook_events (id, event_type, payload, received_at, claimed_at, outcome, attempts)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW(),
+    NOW(),
+    'pending',
+    0
+)
+ON CONFLICT (id) DO NOTHING
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: ClaimWebhookEvent :one
+UPDATE webhook_events
+SET outcome = 'pending', claimed_at = NOW()
+WHERE id = $1
+    AND (outcome = 'failed' OR (outcome = 'pending' AND claimed_at < NOW() - interval '5 minutes'))
+RETURNING *;
+
+-- name: FinishWebhookEvent :one
+UPDATE webhook_events
+SET outcome = $2,
+    error = $3,
+    processed_at = NOW(),
+    attempts = attempts + 1
+WHERE id = $1
+RETURNING *;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE sqlc.arg(outcome)::text = '' OR outcome = sqlc.arg(outcome)::text
+ORDER BY received_at DESC
+LIMIT sqlc.arg(limit_count)
+OFFSET sqlc.arg(offset_count);
\ No newline at end of file
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN last_used_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN last_used_at;
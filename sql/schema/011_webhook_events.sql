@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    id TEXT PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP,
+    outcome TEXT NOT NULL CHECK (outcome IN ('pending', 'processed', 'ignored', 'failed')),
+    error TEXT,
+    attempts INT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX webhook_events_outcome_idx ON webhook_events (outcome, received_at);
+
+-- +goose Down
+DROP TABLE webhook_events;
@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled', 'refunded', 'expired')),
+    current_period_end TIMESTAMP NOT NULL,
+    grace_period_end TIMESTAMP
+);
+
+CREATE TABLE subscription_history (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    status TEXT NOT NULL,
+    current_period_end TIMESTAMP NOT NULL,
+    webhook_event_id TEXT REFERENCES webhook_events(id) ON DELETE SET NULL
+);
+
+CREATE INDEX subscription_history_subscription_idx ON subscription_history (subscription_id, created_at);
+
+-- Existing members keep their membership for one billing period, Polka
+-- renewals take over from there
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, current_period_end)
+SELECT gen_random_uuid(), NOW(), NOW(), id, 'chirpy_red_monthly', 'active', NOW() + INTERVAL '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+ALTER TABLE users
+DROP COLUMN is_chirpy_red;
+
+-- +goose Down
+ALTER TABLE users
+ADD COLUMN is_chirpy_red BOOL NOT NULL DEFAULT false;
+
+UPDATE users
+SET is_chirpy_red = true
+WHERE id IN (
+    SELECT user_id FROM subscriptions
+    WHERE status IN ('active', 'past_due')
+);
+
+DROP TABLE subscription_history;
+DROP TABLE subscriptions;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN media_urls TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE chirps
+DROP COLUMN media_urls;
@@ -0,0 +1,28 @@
+-- +goose Up
+CREATE TABLE outbox (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    published_at TIMESTAMP,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (next_attempt_at) WHERE published_at IS NULL;
+
+-- Webhook deliveries are queued from the outbox, which can dispatch an
+-- event more than once
+ALTER TABLE webhook_deliveries
+ADD COLUMN event_id UUID;
+
+CREATE UNIQUE INDEX webhook_deliveries_event_idx ON webhook_deliveries (subscription_id, event_id);
+
+-- +goose Down
+DROP INDEX webhook_deliveries_event_idx;
+
+ALTER TABLE webhook_deliveries
+DROP COLUMN event_id;
+
+DROP TABLE outbox;
@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE notifications (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type TEXT NOT NULL CHECK (type IN ('reply', 'like', 'follow', 'mention')),
+    target_id UUID,
+    group_key TEXT NOT NULL,
+    actor_ids UUID[] NOT NULL,
+    actor_count INT NOT NULL,
+    read_at TIMESTAMP
+);
+
+-- Unread notifications with the same group key are merged, reading a
+-- notification closes its group
+CREATE UNIQUE INDEX notifications_unread_group_idx ON notifications (user_id, group_key) WHERE read_at IS NULL;
+CREATE INDEX notifications_user_id_idx ON notifications (user_id, updated_at);
+
+CREATE TABLE notification_preferences (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    PRIMARY KEY (user_id, type)
+);
+
+-- +goose Down
+DROP TABLE notification_preferences;
+DROP TABLE notifications;
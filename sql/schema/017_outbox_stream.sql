@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE outbox
+ADD COLUMN seq BIGSERIAL;
+
+CREATE UNIQUE INDEX outbox_seq_idx ON outbox (seq);
+
+-- Lets every instance know about new events, the payload is the event's seq
+-- +goose StatementBegin
+CREATE FUNCTION notify_outbox_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox_events', NEW.seq::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER outbox_notify
+AFTER INSERT ON outbox
+FOR EACH ROW EXECUTE FUNCTION notify_outbox_event();
+
+-- +goose Down
+DROP TRIGGER outbox_notify ON outbox;
+DROP FUNCTION notify_outbox_event;
+
+ALTER TABLE outbox
+DROP COLUMN seq;
@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE filter_rules (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    term TEXT NOT NULL UNIQUE,
+    action TEXT NOT NULL CHECK (action IN ('mask', 'reject', 'flag'))
+);
+
+-- The words that used to be hard-coded
+INSERT INTO filter_rules (id, created_at, updated_at, term, action)
+VALUES
+    (gen_random_uuid (), NOW(), NOW(), 'kerfuffle', 'mask'),
+    (gen_random_uuid (), NOW(), NOW(), 'sharbert', 'mask'),
+    (gen_random_uuid (), NOW(), NOW(), 'fornax', 'mask');
+
+-- Lets every instance reload the rules when they change
+-- +goose StatementBegin
+CREATE FUNCTION notify_filter_rules_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('filter_rules_changed', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER filter_rules_notify
+AFTER INSERT OR UPDATE OR DELETE ON filter_rules
+FOR EACH STATEMENT EXECUTE FUNCTION notify_filter_rules_changed();
+
+CREATE TABLE chirp_flags (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    chirp_id UUID NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    rule_id UUID REFERENCES filter_rules (id) ON DELETE SET NULL,
+    term TEXT NOT NULL,
+    resolved_at TIMESTAMP,
+    resolved_by UUID REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX chirp_flags_unresolved_idx ON chirp_flags (created_at) WHERE resolved_at IS NULL;
+
+-- +goose Down
+DROP TABLE chirp_flags;
+DROP TRIGGER filter_rules_notify ON filter_rules;
+DROP FUNCTION notify_filter_rules_changed;
+DROP TABLE filter_rules;
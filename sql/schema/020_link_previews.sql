@@ -0,0 +1,20 @@
+-- +goose Up
+-- Previews are cached by URL and shared by every chirp linking to it
+CREATE TABLE link_previews (
+    url TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'ready', 'failed')),
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image_url TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    error TEXT,
+    fetched_at TIMESTAMP
+);
+
+CREATE INDEX link_previews_pending_idx ON link_previews (next_attempt_at) WHERE status = 'pending';
+
+-- +goose Down
+DROP TABLE link_previews;
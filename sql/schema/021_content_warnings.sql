@@ -0,0 +1,30 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN content_warning TEXT,
+ADD COLUMN sensitive BOOLEAN NOT NULL DEFAULT false,
+-- Set when a moderator applied the warning, the author can't remove it then
+ADD COLUMN warning_applied_by UUID REFERENCES users (id) ON DELETE SET NULL;
+
+ALTER TABLE users
+ADD COLUMN expand_content_warnings BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN show_sensitive_media BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE users
+DROP CONSTRAINT users_role_check,
+ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'moderator', 'admin'));
+
+-- +goose Down
+UPDATE users SET role = 'user' WHERE role = 'moderator';
+
+ALTER TABLE users
+DROP CONSTRAINT users_role_check,
+ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'admin'));
+
+ALTER TABLE users
+DROP COLUMN expand_content_warnings,
+DROP COLUMN show_sensitive_media;
+
+ALTER TABLE chirps
+DROP COLUMN content_warning,
+DROP COLUMN sensitive,
+DROP COLUMN warning_applied_by;
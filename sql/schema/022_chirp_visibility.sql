@@ -0,0 +1,69 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public' CHECK (visibility IN ('public', 'followers', 'mentioned'));
+
+CREATE TABLE chirp_mentions (
+    chirp_id UUID NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (chirp_id, user_id)
+);
+
+CREATE INDEX chirp_mentions_user_id_idx ON chirp_mentions (user_id);
+
+ALTER TABLE users
+ADD COLUMN is_private BOOLEAN NOT NULL DEFAULT false;
+
+-- Follows of private accounts wait for the followee's approval
+ALTER TABLE follows
+ADD COLUMN status TEXT NOT NULL DEFAULT 'accepted' CHECK (status IN ('pending', 'accepted'));
+
+ALTER TABLE notifications
+DROP CONSTRAINT notifications_type_check,
+ADD CONSTRAINT notifications_type_check CHECK (type IN ('reply', 'like', 'follow', 'follow_request', 'mention'));
+
+-- The one place deciding who can read a chirp, every query reading chirps
+-- goes through it. viewer is NULL for anonymous readers. The author and
+-- mentioned users can always read it, followers-only chirps need an
+-- accepted follow and public chirps of private accounts too.
+-- +goose StatementBegin
+CREATE FUNCTION chirp_visible_to(chirp UUID, author UUID, chirp_visibility TEXT, viewer UUID) RETURNS BOOLEAN AS $$
+    SELECT (viewer IS NOT NULL AND (
+            author = viewer
+            OR EXISTS (SELECT 1 FROM chirp_mentions WHERE chirp_id = chirp AND user_id = viewer)
+        ))
+        OR CASE chirp_visibility
+            WHEN 'mentioned' THEN false
+            WHEN 'followers' THEN EXISTS (
+                SELECT 1 FROM follows
+                WHERE follower_id = viewer AND followee_id = author AND status = 'accepted'
+            )
+            ELSE NOT EXISTS (SELECT 1 FROM users WHERE id = author AND is_private)
+                OR EXISTS (
+                    SELECT 1 FROM follows
+                    WHERE follower_id = viewer AND followee_id = author AND status = 'accepted'
+                )
+        END;
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP FUNCTION chirp_visible_to;
+
+DELETE FROM notifications WHERE type = 'follow_request';
+
+ALTER TABLE notifications
+DROP CONSTRAINT notifications_type_check,
+ADD CONSTRAINT notifications_type_check CHECK (type IN ('reply', 'like', 'follow', 'mention'));
+
+DELETE FROM follows WHERE status = 'pending';
+
+ALTER TABLE follows
+DROP COLUMN status;
+
+ALTER TABLE users
+DROP COLUMN is_private;
+
+DROP TABLE chirp_mentions;
+
+ALTER TABLE chirps
+DROP COLUMN visibility;
@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE lists (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    owner_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    -- Private lists are only seen by their owner
+    is_private BOOLEAN NOT NULL DEFAULT false,
+    UNIQUE (owner_id, name)
+);
+
+CREATE TABLE list_members (
+    list_id UUID NOT NULL REFERENCES lists (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (list_id, user_id)
+);
+
+CREATE TABLE list_subscriptions (
+    list_id UUID NOT NULL REFERENCES lists (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (list_id, user_id)
+);
+
+CREATE INDEX list_subscriptions_user_id_idx ON list_subscriptions (user_id);
+
+-- +goose Down
+DROP TABLE list_subscriptions;
+
+DROP TABLE list_members;
+
+DROP TABLE lists;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- A viewer counts once per chirp, kind and hour. Rows are kept until the
+-- hour is over, then only the rollups are.
+CREATE TABLE chirp_view_events (
+    chirp_id UUID NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('impression', 'view')),
+    viewer TEXT NOT NULL,
+    window_start TIMESTAMP NOT NULL,
+    rolled_up BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (chirp_id, kind, viewer, window_start)
+);
+
+CREATE INDEX chirp_view_events_pending_idx ON chirp_view_events (window_start) WHERE NOT rolled_up;
+
+CREATE TABLE chirp_view_rollups (
+    chirp_id UUID NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    hour TIMESTAMP NOT NULL,
+    impressions BIGINT NOT NULL DEFAULT 0,
+    views BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (chirp_id, hour)
+);
+
+-- +goose Down
+DROP TABLE chirp_view_rollups;
+
+DROP TABLE chirp_view_events;
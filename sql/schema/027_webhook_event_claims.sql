@@ -0,0 +1,14 @@
+-- +goose Up
+-- Set when an instance starts processing the event. A pending event whose
+-- claim is old was interrupted and can be processed again.
+ALTER TABLE webhook_events
+ADD COLUMN claimed_at TIMESTAMP;
+
+UPDATE webhook_events SET claimed_at = received_at;
+
+ALTER TABLE webhook_events
+ALTER COLUMN claimed_at SET NOT NULL;
+
+-- +goose Down
+ALTER TABLE webhook_events
+DROP COLUMN claimed_at;
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE chirp_likes (
+    chirp_id UUID NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (chirp_id, user_id)
+);
+
+-- A reply outlives the chirp it answers
+ALTER TABLE chirps
+ADD COLUMN reply_to_id UUID REFERENCES chirps (id) ON DELETE SET NULL;
+
+CREATE INDEX chirps_reply_to_id_idx ON chirps (reply_to_id) WHERE reply_to_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX chirps_reply_to_id_idx;
+
+ALTER TABLE chirps
+DROP COLUMN reply_to_id;
+
+DROP TABLE chirp_likes;
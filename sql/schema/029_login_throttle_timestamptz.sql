@@ -0,0 +1,10 @@
+-- +goose Up
+-- last_failure_at is set with NOW() and compared with times computed by the
+-- server. As TIMESTAMP, NOW() is stored in the session's time zone and the
+-- comparisons are off when it isn't UTC.
+ALTER TABLE login_throttles
+ALTER COLUMN last_failure_at TYPE TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE login_throttles
+ALTER COLUMN last_failure_at TYPE TIMESTAMP;
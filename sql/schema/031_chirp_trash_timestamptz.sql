@@ -0,0 +1,10 @@
+-- +goose Up
+-- deleted_at is set with NOW() and compared with the retention cutoff
+-- computed by the server. As TIMESTAMP the two are off by the session's
+-- offset when it isn't UTC.
+ALTER TABLE chirps
+ALTER COLUMN deleted_at TYPE TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE chirps
+ALTER COLUMN deleted_at TYPE TIMESTAMP;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- next_attempt_at is set with NOW() and with leases and retry times
+-- computed by the server, and compared with NOW(). published_at is set with
+-- NOW() and compared with the retention cutoff computed by the server. As
+-- TIMESTAMP they are off by the session's offset when it isn't UTC.
+ALTER TABLE outbox
+ALTER COLUMN next_attempt_at TYPE TIMESTAMPTZ,
+ALTER COLUMN published_at TYPE TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE outbox
+ALTER COLUMN next_attempt_at TYPE TIMESTAMP,
+ALTER COLUMN published_at TYPE TIMESTAMP;
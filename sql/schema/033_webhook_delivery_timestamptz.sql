@@ -0,0 +1,10 @@
+-- +goose Up
+-- next_attempt_at is set with NOW() and with leases and retry times
+-- computed by the server, and compared with NOW(). As TIMESTAMP the two are
+-- off by the session's offset when it isn't UTC.
+ALTER TABLE webhook_deliveries
+ALTER COLUMN next_attempt_at TYPE TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE webhook_deliveries
+ALTER COLUMN next_attempt_at TYPE TIMESTAMP;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- The period ends come from Polka through the server and are compared with
+-- NOW(). As TIMESTAMP they are off by the session's offset when it isn't
+-- UTC, and memberships start or end early or late.
+ALTER TABLE subscriptions
+ALTER COLUMN current_period_end TYPE TIMESTAMPTZ USING current_period_end AT TIME ZONE 'UTC',
+ALTER COLUMN grace_period_end TYPE TIMESTAMPTZ USING grace_period_end AT TIME ZONE 'UTC';
+
+-- +goose Down
+ALTER TABLE subscriptions
+ALTER COLUMN current_period_end TYPE TIMESTAMP USING current_period_end AT TIME ZONE 'UTC',
+ALTER COLUMN grace_period_end TYPE TIMESTAMP USING grace_period_end AT TIME ZONE 'UTC';
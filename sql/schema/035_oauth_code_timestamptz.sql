@@ -0,0 +1,9 @@
+-- +goose Up
+-- expires_at is computed by the server and compared with NOW(). As
+-- TIMESTAMP the two are off by the session's offset when it isn't UTC.
+ALTER TABLE oauth_authorization_codes
+ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE 'UTC';
+
+-- +goose Down
+ALTER TABLE oauth_authorization_codes
+ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE 'UTC';
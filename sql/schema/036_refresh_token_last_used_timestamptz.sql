@@ -0,0 +1,10 @@
+-- +goose Up
+-- last_used_at is set with NOW() and compared with the idle cutoff computed
+-- by the server. As TIMESTAMP the two are off by the session's offset when
+-- it isn't UTC.
+ALTER TABLE refresh_tokens
+ALTER COLUMN last_used_at TYPE TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+ALTER COLUMN last_used_at TYPE TIMESTAMP;
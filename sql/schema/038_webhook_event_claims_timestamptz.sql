@@ -0,0 +1,9 @@
+-- +goose Up
+-- Stored as TIMESTAMPTZ like the other times compared with NOW(), so claims
+-- don't depend on the session's time zone.
+ALTER TABLE webhook_events
+ALTER COLUMN claimed_at TYPE TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE webhook_events
+ALTER COLUMN claimed_at TYPE TIMESTAMP;
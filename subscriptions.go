@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/billing"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+)
+
+// handlePolkaSubscriptionEvent moves the user's subscription to its next
+// state and records the change in the subscription history, in one
+// transaction.
+func (cfg *apiConfig) handlePolkaSubscriptionEvent(ctx context.Context, event database.WebhookEvent, data json.RawMessage) error {
+	params := struct {
+		UserID           uuid.UUID `json:"user_id"`
+		Plan             string    `json:"plan"`
+		CurrentPeriodEnd time.Time `json:"current_period_end"`
+	}{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return err
+	}
+
+	if _, err := cfg.DB.GetUserByID(ctx, params.UserID); err == sql.ErrNoRows {
+		return errWebhookUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	return cfg.DB.InTx(ctx, func(q *database.Queries) error {
+		// Locked until the transaction ends, so concurrent events for the
+		// same user and the nightly expiry are applied one after the other
+		var current *billing.State
+		subscription, err := q.GetSubscriptionByUserForUpdate(ctx, params.UserID)
+		if err == nil {
+			state := subscriptionState(subscription)
+			current = &state
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+
+		next, err := billing.Apply(current, billing.Event{
+			Type:             event.EventType,
+			Plan:             params.Plan,
+			CurrentPeriodEnd: params.CurrentPeriodEnd.UTC(),
+		}, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		subscription, err = q.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+			UserID:           params.UserID,
+			Plan:             next.Plan,
+			Status:           next.Status,
+			CurrentPeriodEnd: next.CurrentPeriodEnd,
+			GracePeriodEnd:   sql.NullTime{Time: next.GracePeriodEnd, Valid: !next.GracePeriodEnd.IsZero()},
+		})
+		if err != nil {
+			return err
+		}
+
+		return q.CreateSubscriptionHistory(ctx, database.CreateSubscriptionHistoryParams{
+			SubscriptionID:   subscription.ID,
+			Event:            event.EventType,
+			Status:           subscription.Status,
+			CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+			WebhookEventID:   sql.NullString{String: event.ID, Valid: true},
+		})
+	})
+}
+
+// expireLapsedSubscriptions runs every night. Lapsed memberships already
+// stop counting as Chirpy Red when their period ends, the job records the
+// transition so the status and history reflect it.
+func (cfg *apiConfig) expireLapsedSubscriptions(ctx context.Context) error {
+	var expired []database.Subscription
+	err := cfg.DB.InTx(ctx, func(q *database.Queries) error {
+		var err error
+		expired, err = q.ExpireLapsedSubscriptions(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, subscription := range expired {
+			if err := q.CreateSubscriptionHistory(ctx, database.CreateSubscriptionHistoryParams{
+				SubscriptionID:   subscription.ID,
+				Event:            billing.EventExpired,
+				Status:           subscription.Status,
+				CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Expired %d lapsed subscriptions", len(expired))
+	return nil
+}
+
+func subscriptionState(subscription database.Subscription) billing.State {
+	return billing.State{
+		Plan:             subscription.Plan,
+		Status:           subscription.Status,
+		CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+		GracePeriodEnd:   subscription.GracePeriodEnd.Time,
+	}
+}
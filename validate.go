@@ -1,27 +1,89 @@
 package main
 
 import (
+	"database/sql"
+	"fmt"
+	"net/url"
 	"strings"
+
+	"github.com/miguelsoffarelli/chirpy/internal/billing"
+	"github.com/miguelsoffarelli/chirpy/internal/chirptext"
 )
 
-func validateChirp(params *chirpParameters) bool {
-	// length check
-	const maxChirpLen = 140
-	if len(params.Body) > maxChirpLen {
-		return false
+// validateChirp normalizes the chirp body and checks it and its media. The
+// length is what the user sees, see chirptext.Length.
+func validateChirp(v *validator, params *chirpParameters, entitlements billing.Entitlements) {
+	params.Body = strings.TrimSpace(chirptext.Normalize(params.Body))
+
+	if chirptext.IsBlank(params.Body) {
+		v.add("body", fieldRequired, "Chirp can't be empty")
+	} else if length := chirptext.Length(params.Body); length > entitlements.MaxChirpLength {
+		v.add("body", fieldTooLong, fmt.Sprintf("Chirp is %d characters long, it can have at most %d", length, entitlements.MaxChirpLength))
 	}
 
-	clearChirp(params)
-	return true
+	validateChirpMedia(v, params.Media, entitlements)
 }
 
-func clearChirp(p *chirpParameters) {
-	body := strings.Split(p.Body, " ")
-	for i, word := range body {
-		if strings.ToLower(word) == "kerfuffle" || strings.ToLower(word) == "sharbert" || strings.ToLower(word) == "fornax" {
-			body[i] = "****"
-		}
+const maxContentWarningLength = 100
+
+// validateContentWarning normalizes a content warning like a chirp body and
+// checks it. A blank warning is the same as none.
+func validateContentWarning(v *validator, warning *string) sql.NullString {
+	if warning == nil {
+		return sql.NullString{}
+	}
+
+	text := strings.TrimSpace(chirptext.Normalize(*warning))
+	if chirptext.IsBlank(text) {
+		return sql.NullString{}
 	}
 
-	p.Body = strings.Join(body, " ")
+	if length := chirptext.Length(text); length > maxContentWarningLength {
+		v.add("content_warning", fieldTooLong, fmt.Sprintf("Content warning is %d characters long, it can have at most %d", length, maxContentWarningLength))
+	}
+
+	return sql.NullString{String: text, Valid: true}
+}
+
+const (
+	visibilityPublic    = "public"
+	visibilityFollowers = "followers"
+	visibilityMentioned = "mentioned"
+)
+
+const maxMentionsPerChirp = 50
+
+// validateChirpAudience checks who the chirp is for. Chirps are public
+// unless told otherwise, and a chirp for the mentioned users must mention
+// someone.
+func validateChirpAudience(v *validator, params *chirpParameters) {
+	if params.Visibility == "" {
+		params.Visibility = visibilityPublic
+	}
+
+	switch params.Visibility {
+	case visibilityPublic, visibilityFollowers:
+	case visibilityMentioned:
+		v.check(len(params.Mentions) > 0, "mentions", fieldRequired, "Chirps for the mentioned users must mention someone")
+	default:
+		v.add("visibility", fieldInvalid, "Visibility must be public, followers or mentioned")
+	}
+
+	v.check(len(params.Mentions) <= maxMentionsPerChirp, "mentions", fieldTooMany, fmt.Sprintf("Chirps can mention at most %d users", maxMentionsPerChirp))
+}
+
+// validateChirpMedia checks the media attached to a chirp, which are links
+// to images hosted elsewhere.
+func validateChirpMedia(v *validator, media []string, entitlements billing.Entitlements) {
+	if len(media) > entitlements.MaxMediaPerChirp {
+		v.add("media", fieldTooMany, fmt.Sprintf("Chirps can have at most %d media", entitlements.MaxMediaPerChirp))
+		return
+	}
+
+	for i, link := range media {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add(fmt.Sprintf("media[%d]", i), fieldInvalid, "Media must be http or https URLs")
+		}
+	}
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miguelsoffarelli/chirpy/internal/database"
+	"github.com/miguelsoffarelli/chirpy/internal/outbox"
+	"github.com/miguelsoffarelli/chirpy/internal/webhooks"
+)
+
+const (
+	webhookDeliveryBatchSize = 20
+	// Claimed deliveries are leased for this long, if the instance sending
+	// them dies they are picked up again once the lease runs out.
+	webhookDeliveryLease = 5 * time.Minute
+)
+
+// forwardToWebhooks queues a delivery of an outbox event to each of the
+// owner's subscriptions to it. Events are only queued once per subscription
+// even if the outbox dispatches them again.
+func (cfg *apiConfig) forwardToWebhooks(ctx context.Context, event outbox.Event) error {
+	var owner struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if err := json.Unmarshal(event.Payload, &owner); err != nil {
+		return err
+	}
+
+	payload, err := webhooks.NewPayload(event.Type, event.Payload, event.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.DB.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:   event.ID,
+		EventType: event.Type,
+		Payload:   payload,
+		UserID:    owner.UserID,
+	})
+	return err
+}
+
+// deliverWebhooks sends the deliveries that are due.
+func (cfg *apiConfig) deliverWebhooks(ctx context.Context) error {
+	deliveries, err := cfg.DB.ClaimDueWebhookDeliveries(ctx, database.ClaimDueWebhookDeliveriesParams{
+		LeaseUntil: time.Now().UTC().Add(webhookDeliveryLease),
+		BatchSize:  webhookDeliveryBatchSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if err := cfg.deliverWebhook(ctx, delivery); err != nil {
+			log.Printf("couldn't record webhook delivery %s: %v", delivery.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, delivery database.WebhookDelivery) error {
+	subscription, err := cfg.DB.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	result := cfg.WebhookSender.Send(ctx, webhooks.Delivery{
+		ID:      delivery.ID.String(),
+		Event:   delivery.EventType,
+		URL:     subscription.Url,
+		Secret:  subscription.Secret,
+		Payload: delivery.Payload,
+	})
+
+	attempt := database.CreateWebhookDeliveryAttemptParams{
+		DeliveryID: delivery.ID,
+		DurationMs: int32(result.Duration.Milliseconds()),
+	}
+	if result.StatusCode != 0 {
+		attempt.StatusCode = sql.NullInt32{Int32: int32(result.StatusCode), Valid: true}
+	}
+	if result.Err != nil {
+		attempt.Error = sql.NullString{String: result.Err.Error(), Valid: true}
+	}
+	if err := cfg.DB.CreateWebhookDeliveryAttempt(ctx, attempt); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	finish := database.FinishWebhookDeliveryAttemptParams{
+		ID:            delivery.ID,
+		Status:        webhookDeliveryDelivered,
+		NextAttemptAt: now,
+	}
+	if result.OK() {
+		finish.DeliveredAt = sql.NullTime{Time: now, Valid: true}
+	} else if delay, retry := cfg.WebhookRetry.Backoff(int(delivery.Attempts) + 1); retry {
+		finish.Status = webhookDeliveryPending
+		finish.NextAttemptAt = now.Add(delay)
+	} else {
+		finish.Status = webhookDeliveryDead
+	}
+
+	_, err = cfg.DB.FinishWebhookDeliveryAttempt(ctx, finish)
+	return err
+}